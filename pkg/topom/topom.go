@@ -328,6 +328,10 @@ func (s *Topom) Stats() (*Stats, error) {
 			stats.HA.Masters[strconv.Itoa(gid)] = addr
 		}
 	}
+
+	stats.RedisPool.Action = s.action.redisp.Stats()
+	stats.RedisPool.Stats = s.stats.redisp.Stats()
+	stats.RedisPool.HA = s.ha.redisp.Stats()
 	return stats, nil
 }
 
@@ -362,6 +366,12 @@ type Stats struct {
 		Stats   map[string]*RedisStats `json:"stats"`
 		Masters map[string]string      `json:"masters"`
 	} `json:"sentinels"`
+
+	RedisPool struct {
+		Action *redis.PoolStats `json:"action"`
+		Stats  *redis.PoolStats `json:"stats"`
+		HA     *redis.PoolStats `json:"ha"`
+	} `json:"redis_pool"`
 }
 
 func (s *Topom) Config() *Config {
@@ -194,7 +194,15 @@ func (s *Topom) GroupDelServer(gid int, addr string) error {
 
 	g.Servers = slice
 
-	return s.storeUpdateGroup(g)
+	if err := s.storeUpdateGroup(g); err != nil {
+		return err
+	}
+	for _, p := range []*redis.Pool{
+		s.action.redisp, s.stats.redisp, s.ha.redisp,
+	} {
+		p.Remove(addr)
+	}
+	return nil
 }
 
 func (s *Topom) GroupPromoteServer(gid int, addr string) error {
@@ -601,3 +609,82 @@ func (s *Topom) newSyncActionExecutor(addr string) (func() error, error) {
 		return nil
 	}, nil
 }
+
+// GroupConfigDiff runs CONFIG GET * on every server of the group and returns
+// the parameters whose values differ, keyed by parameter then by server addr.
+// A parameter missing on some server is reported with an empty value there.
+// Parameters that always differ between instances, see perInstanceConfigs,
+// are left out. Servers that fail are reported in the errors by addr, like
+// InfoMany, and the rest are still compared.
+func (s *Topom) GroupConfigDiff(gid int) (map[string]map[string]string, map[string]error, error) {
+	s.mu.Lock()
+	ctx, err := s.newContext()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, nil, err
+	}
+	g, err := ctx.getGroup(gid)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, nil, err
+	}
+	var addrs []string
+	for _, x := range g.Servers {
+		addrs = append(addrs, x.Addr)
+	}
+	s.mu.Unlock()
+
+	var configs = make(map[string]map[string]string)
+	var errs = make(map[string]error)
+	for _, addr := range addrs {
+		c, err := s.stats.redisp.GetClient(addr)
+		if err != nil {
+			errs[addr] = err
+			continue
+		}
+		m, err := c.ConfigGetAll()
+		s.stats.redisp.PutClient(c)
+		if err != nil {
+			log.WarnErrorf(err, "redis %s config get failed", addr)
+			errs[addr] = err
+			continue
+		}
+		configs[addr] = m
+	}
+	return diffConfigs(configs), errs, nil
+}
+
+// perInstanceConfigs are expected to differ between the servers of a group.
+var perInstanceConfigs = map[string]bool{
+	"slaveof": true, "replicaof": true,
+	"bind": true, "port": true, "unixsocket": true,
+	"dir": true, "pidfile": true, "logfile": true,
+	"dbfilename": true, "appendfilename": true,
+	"slave-announce-ip": true, "slave-announce-port": true,
+	"replica-announce-ip": true, "replica-announce-port": true,
+	"cluster-config-file": true,
+}
+
+func diffConfigs(configs map[string]map[string]string) map[string]map[string]string {
+	var keys = make(map[string]bool)
+	for _, m := range configs {
+		for k := range m {
+			if !perInstanceConfigs[k] {
+				keys[k] = true
+			}
+		}
+	}
+	var diff = make(map[string]map[string]string)
+	for k := range keys {
+		var values = make(map[string]string)
+		var unique = make(map[string]bool)
+		for addr, m := range configs {
+			values[addr] = m[k]
+			unique[m[k]] = true
+		}
+		if len(unique) > 1 {
+			diff[k] = values
+		}
+	}
+	return diff
+}
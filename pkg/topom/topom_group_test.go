@@ -198,3 +198,15 @@ func TestGroupPromote(x *testing.T) {
 	assert.Must(g4.Servers[0].Addr == server2)
 	assert.Must(g4.Servers[1].Addr == server1)
 }
+
+func TestDiffConfigs(x *testing.T) {
+	diff := diffConfigs(map[string]map[string]string{
+		"server1:port": {"appendonly": "no", "maxmemory-policy": "noeviction", "port": "6379", "slaveof": ""},
+		"server2:port": {"appendonly": "yes", "maxmemory-policy": "noeviction", "port": "6380", "slaveof": "server1 6379"},
+		"server3:port": {"maxmemory-policy": "noeviction", "slave-read-only": "yes", "port": "6381"},
+	})
+	assert.Must(len(diff) == 2)
+	assert.Must(diff["appendonly"]["server2:port"] == "yes")
+	assert.Must(diff["appendonly"]["server3:port"] == "")
+	assert.Must(diff["slave-read-only"]["server3:port"] == "yes")
+}
@@ -431,6 +431,13 @@ func (s *Topom) newSlotActionExecutor(sid int) (func(db int) (remains int, nextd
 				return n, db, nil
 			}
 
+			if empty, err := c.SlotsKeysEmpty(sid); err != nil {
+				return 0, -1, err
+			} else if !empty {
+				log.Errorf("slot-[%d] db-[%d] is not empty after migration from %s to %s", sid, db, from, dest)
+				return 0, -1, errors.Errorf("slot-[%d] db-[%d] is not empty after migration", sid, db)
+			}
+
 			nextdb := -1
 			m, err := c.InfoKeySpace()
 			if err != nil {
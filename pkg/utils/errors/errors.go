@@ -50,6 +50,31 @@ func Errorf(format string, v ...interface{}) error {
 	}
 }
 
+// WrappedError carries the detail of a failure while Cause still returns the
+// sentinel, e.g. "dial redis failed: connection refused".
+type WrappedError struct {
+	Sentinel error
+	Detail   error
+}
+
+func (e *WrappedError) Error() string {
+	return e.Sentinel.Error() + ": " + e.Detail.Error()
+}
+
+func Wrap(sentinel, detail error) error {
+	var err = sentinel
+	if detail != nil {
+		err = &WrappedError{Sentinel: sentinel, Detail: Cause(detail)}
+	}
+	if !TraceEnabled {
+		return err
+	}
+	return &TracedError{
+		Stack: trace.TraceN(1, 32),
+		Cause: err,
+	}
+}
+
 func Stack(err error) trace.Stack {
 	if err == nil {
 		return nil
@@ -63,10 +88,12 @@ func Stack(err error) trace.Stack {
 
 func Cause(err error) error {
 	for err != nil {
-		e, ok := err.(*TracedError)
-		if ok {
+		switch e := err.(type) {
+		case *TracedError:
 			err = e.Cause
-		} else {
+		case *WrappedError:
+			err = e.Sentinel
+		default:
 			return err
 		}
 	}
@@ -5,21 +5,57 @@ package redis
 
 import (
 	"container/list"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/math2"
+	"github.com/CodisLabs/codis/pkg/utils/sync2/atomic2"
 
 	redigo "github.com/garyburd/redigo/redis"
 )
 
+const MaxSlotNum = 1024
+
+func validateSlot(slot int) error {
+	if slot < 0 || slot >= MaxSlotNum {
+		return errors.Errorf("invalid slot id = %d, must be in [0,%d)", slot, MaxSlotNum)
+	}
+	return nil
+}
+
+var (
+	ErrRedisDial = errors.New("dial redis failed")
+	ErrRedisAuth = errors.New("auth redis failed")
+
+	ErrRedisAuthTimeout = errors.New("auth redis timeout")
+)
+
+// IsAuthError reports whether AUTH failed while creating a client, either
+// rejected by the server (ErrRedisAuth) or timed out (ErrRedisAuthTimeout).
+func IsAuthError(err error) bool {
+	switch errors.Cause(err) {
+	case ErrRedisAuth, ErrRedisAuthTimeout:
+		return true
+	}
+	return false
+}
+
 type Client struct {
 	conn redigo.Conn
+	sock net.Conn
 	Addr string
+	User string
 	Auth string
 
 	Database int
@@ -27,6 +63,16 @@ type Client struct {
 	LastUse time.Time
 	Timeout time.Duration
 
+	CreatedAt time.Time
+
+	// DialLatency is the time spent on connecting, tls handshake included.
+	DialLatency time.Duration
+
+	// MigrateTimeout is passed to SLOTSMGRTTAGSLOT as the per-call timeout,
+	// Timeout is used instead if it's zero. If both are zero, codis-server
+	// falls back to its builtin default (100ms).
+	MigrateTimeout time.Duration
+
 	Pipeline struct {
 		Send, Recv uint64
 	}
@@ -37,24 +83,162 @@ func NewClientNoAuth(addr string, timeout time.Duration) (*Client, error) {
 }
 
 func NewClient(addr string, auth string, timeout time.Duration) (*Client, error) {
-	c, err := redigo.Dial("tcp", addr, []redigo.DialOption{
-		redigo.DialConnectTimeout(math2.MinDuration(time.Second, timeout)),
-		redigo.DialPassword(auth),
-		redigo.DialReadTimeout(timeout), redigo.DialWriteTimeout(timeout),
-	}...)
+	return NewClientWithUser(addr, "", auth, timeout)
+}
+
+func NewClientWithUser(addr string, user, auth string, timeout time.Duration) (*Client, error) {
+	return NewClientWithOption(addr, &ClientOption{
+		User: user, Auth: auth, Timeout: timeout,
+	})
+}
+
+type ClientOption struct {
+	User string
+	Auth string
+
+	// Database is selected right after AUTH if it's not 0.
+	Database int
+
+	Timeout time.Duration
+
+	// DialTimeout bounds the tcp connect (and tls handshake), and separately
+	// the AUTH that follows, it's decoupled from Timeout. If it's zero,
+	// min(1s, Timeout) is used.
+	DialTimeout time.Duration
+
+	// MigrateTimeout is copied to Client.MigrateTimeout.
+	MigrateTimeout time.Duration
+
+	// KeepAlive is the tcp keepalive period, it follows net.Dialer, zero
+	// means the system default and negative disables it. TCP_NODELAY is on
+	// unless DisableNoDelay is set.
+	KeepAlive      time.Duration
+	DisableNoDelay bool
+
+	// TLSConfig is only used when TLSEnabled is set, the server name is
+	// taken from addr if it's not specified in the config.
+	TLSEnabled bool
+	TLSConfig  *tls.Config
+}
+
+func NewClientWithOption(addr string, option *ClientOption) (*Client, error) {
+	start := time.Now()
+	sock, err := option.dialer()(dialNetwork(addr))
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, errors.Wrap(ErrRedisDial, err)
+	}
+	latency := time.Since(start)
+	c := &Client{
+		conn: redigo.NewConn(sock, 0, 0), sock: sock,
+		Addr: addr, User: option.User, Auth: option.Auth,
+		LastUse: time.Now(), Timeout: option.Timeout,
+
+		CreatedAt: time.Now(), DialLatency: latency,
+
+		MigrateTimeout: option.MigrateTimeout,
+	}
+	if option.Auth != "" {
+		var args = []interface{}{option.Auth}
+		if option.User != "" {
+			args = []interface{}{option.User, option.Auth}
+		}
+		if _, err := c.DoTimeout(option.dialTimeout(), "AUTH", args...); err != nil {
+			c.Close()
+			switch e := errors.Cause(err).(type) {
+			case redigo.Error:
+				return nil, errors.Wrap(ErrRedisAuth, e)
+			case net.Error:
+				if e.Timeout() {
+					return nil, errors.Wrap(ErrRedisAuthTimeout, e)
+				}
+			}
+			return nil, errors.Wrap(ErrRedisDial, err)
+		}
+	}
+	if err := c.Select(option.Database); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialNetwork returns "unix" if addr is prefixed by "unix:" or looks like a
+// filesystem path, e.g. /tmp/redis.sock, otherwise it's a tcp address.
+func dialNetwork(addr string) (network, address string) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "./"):
+		return "unix", addr
+	}
+	return "tcp", addr
+}
+
+func (o *ClientOption) dialTimeout() time.Duration {
+	if o.DialTimeout != 0 {
+		return o.DialTimeout
+	}
+	return math2.MinDuration(time.Second, o.Timeout)
+}
+
+func (o *ClientOption) dialer() func(network, addr string) (net.Conn, error) {
+	timeout := o.dialTimeout()
+	return func(network, addr string) (net.Conn, error) {
+		d := &net.Dialer{Timeout: timeout, KeepAlive: o.KeepAlive}
+		conn, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok && o.DisableNoDelay {
+			tcp.SetNoDelay(false)
+		}
+		if !o.TLSEnabled {
+			return conn, nil
+		}
+		config := &tls.Config{}
+		if o.TLSConfig != nil {
+			config = o.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			config.ServerName = host
+		}
+		tlsConn := tls.Client(conn, config)
+		if timeout != 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
 	}
-	return &Client{
-		conn: c, Addr: addr, Auth: auth,
-		LastUse: time.Now(), Timeout: timeout,
-	}, nil
 }
 
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
+// RemoteAddr and LocalAddr return the addresses of the underlying socket,
+// which helps to match the client against CLIENT LIST on the server.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.sock.RemoteAddr()
+}
+
+func (c *Client) LocalAddr() net.Addr {
+	return c.sock.LocalAddr()
+}
+
+// Healthy reports whether the connection is still usable, it doesn't send
+// any command to the server.
+func (c *Client) Healthy() bool {
+	return c.conn.Err() == nil
+}
+
 func (c *Client) isRecyclable() bool {
 	switch {
 	case c.conn.Err() != nil:
@@ -67,21 +251,91 @@ func (c *Client) isRecyclable() bool {
 	return true
 }
 
+func (c *Client) deadline(timeout time.Duration) time.Time {
+	if timeout != 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
 func (c *Client) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoTimeout(c.Timeout, cmd, args...)
+}
+
+func (c *Client) DoTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	c.sock.SetDeadline(c.deadline(timeout))
 	r, err := c.conn.Do(cmd, args...)
 	if err != nil {
-		c.Close()
+		if e, ok := err.(redigo.Error); !ok {
+			c.Close()
+		} else {
+			c.LastUse = time.Now()
+			err = newRedirectError(e)
+		}
 		return nil, errors.Trace(err)
 	}
 	c.LastUse = time.Now()
+	return r, nil
+}
 
-	if err, ok := r.(redigo.Error); ok {
-		return nil, errors.Trace(err)
+// RedirectError is returned instead of the plain error reply when the server
+// answers with MOVED or ASK, the connection is still healthy.
+type RedirectError struct {
+	Slot int
+	Addr string
+	Ask  bool
+}
+
+func (e *RedirectError) Error() string {
+	if e.Ask {
+		return fmt.Sprintf("ASK %d %s", e.Slot, e.Addr)
+	}
+	return fmt.Sprintf("MOVED %d %s", e.Slot, e.Addr)
+}
+
+func newRedirectError(e redigo.Error) error {
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return e
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return e
+	}
+	return &RedirectError{Slot: slot, Addr: fields[2], Ask: fields[0] == "ASK"}
+}
+
+func (c *Client) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return c.doContext(ctx, c.Timeout, cmd, args...)
+}
+
+func (c *Client) doContext(ctx context.Context, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if ctx.Done() == nil {
+		return c.DoTimeout(timeout, cmd, args...)
+	}
+	type result struct {
+		r   interface{}
+		err error
+	}
+	var exit = make(chan result, 1)
+
+	go func() {
+		r, err := c.DoTimeout(timeout, cmd, args...)
+		exit <- result{r, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		c.Close()
+		<-exit
+		return nil, errors.Trace(ctx.Err())
+	case x := <-exit:
+		return x.r, x.err
 	}
-	return r, nil
 }
 
 func (c *Client) Send(cmd string, args ...interface{}) error {
+	c.sock.SetWriteDeadline(c.deadline(c.Timeout))
 	if err := c.conn.Send(cmd, args...); err != nil {
 		c.Close()
 		return errors.Trace(err)
@@ -91,6 +345,7 @@ func (c *Client) Send(cmd string, args ...interface{}) error {
 }
 
 func (c *Client) Flush() error {
+	c.sock.SetWriteDeadline(c.deadline(c.Timeout))
 	if err := c.conn.Flush(); err != nil {
 		c.Close()
 		return errors.Trace(err)
@@ -99,21 +354,88 @@ func (c *Client) Flush() error {
 }
 
 func (c *Client) Receive() (interface{}, error) {
+	c.sock.SetReadDeadline(c.deadline(c.Timeout))
 	r, err := c.conn.Receive()
 	if err != nil {
-		c.Close()
-		return nil, errors.Trace(err)
+		if e, ok := err.(redigo.Error); !ok {
+			c.Close()
+			return nil, errors.Trace(err)
+		} else {
+			err = newRedirectError(e)
+		}
 	}
 	c.Pipeline.Recv++
 
 	c.LastUse = time.Now()
 
-	if err, ok := r.(redigo.Error); ok {
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	return r, nil
 }
 
+var ErrTxAborted = errors.New("transaction is aborted")
+
+// Tx queues commands inside Client.Transaction.
+type Tx struct {
+	c *Client
+}
+
+func (tx *Tx) Send(cmd string, args ...interface{}) error {
+	if err := tx.c.conn.Send(cmd, args...); err != nil {
+		tx.c.Close()
+		return errors.Trace(err)
+	}
+	tx.c.Pipeline.Send++
+	return nil
+}
+
+// Transaction sends MULTI, lets fn queue commands with tx.Send and runs
+// EXEC, the replies of the queued commands are returned in order. If fn
+// fails the transaction is discarded and fn's error is returned. The first
+// error replied by a queued command, if any, is returned along with the
+// replies. Like Do, the client is closed if the connection breaks.
+// MULTI and the queued commands are counted in Pipeline until EXEC or
+// DISCARD reads their replies, so a client left behind by a panic in fn is
+// never recycled by a pool.
+func (c *Client) Transaction(fn func(tx *Tx) error) ([]interface{}, error) {
+	if err := c.conn.Send("MULTI"); err != nil {
+		c.Close()
+		return nil, errors.Trace(err)
+	}
+	c.Pipeline.Send++
+
+	do := func(cmd string) (interface{}, error) {
+		reply, err := c.Do(cmd)
+		if c.conn.Err() == nil {
+			// redigo's Do has read all of the pending replies.
+			c.Pipeline.Recv = c.Pipeline.Send
+		}
+		return reply, err
+	}
+	if err := fn(&Tx{c}); err != nil {
+		do("DISCARD")
+		return nil, err
+	}
+	reply, err := do("EXEC")
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, errors.Trace(ErrTxAborted)
+	}
+	values, err := redigo.Values(reply, nil)
+	if err != nil {
+		return nil, errors.Errorf("invalid response = %v", reply)
+	}
+	for _, r := range values {
+		if err, ok := r.(redigo.Error); ok {
+			return values, errors.Trace(err)
+		}
+	}
+	return values, nil
+}
+
 func (c *Client) Select(database int) error {
 	if c.Database == database {
 		return nil
@@ -127,20 +449,35 @@ func (c *Client) Select(database int) error {
 	return nil
 }
 
-func (c *Client) Shutdown() error {
-	_, err := c.Do("SHUTDOWN")
-	if err != nil {
-		c.Close()
+func (c *Client) Ping() error {
+	if _, err := c.Do("PING"); err != nil {
 		return errors.Trace(err)
 	}
 	return nil
 }
 
+func (c *Client) Shutdown() error {
+	return c.shutdown()
+}
+
 func (c *Client) Info() (map[string]string, error) {
 	text, err := redigo.String(c.Do("INFO"))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	return parseInfo(text), nil
+}
+
+// InfoSection returns fields of the given section only, e.g. "memory".
+func (c *Client) InfoSection(section string) (map[string]string, error) {
+	text, err := redigo.String(c.Do("INFO", section))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parseInfo(text), nil
+}
+
+func parseInfo(text string) map[string]string {
 	info := make(map[string]string)
 	for _, line := range strings.Split(text, "\n") {
 		kv := strings.SplitN(line, ":", 2)
@@ -151,7 +488,7 @@ func (c *Client) Info() (map[string]string, error) {
 			info[key] = strings.TrimSpace(kv[1])
 		}
 	}
-	return info, nil
+	return info
 }
 
 func (c *Client) InfoKeySpace() (map[int]string, error) {
@@ -180,10 +517,8 @@ func (c *Client) InfoFull() (map[string]string, error) {
 	if info, err := c.Info(); err != nil {
 		return nil, errors.Trace(err)
 	} else {
-		host := info["master_host"]
-		port := info["master_port"]
-		if host != "" || port != "" {
-			info["master_addr"] = net.JoinHostPort(host, port)
+		if addr := NewReplicationInfo(info).MasterAddr(); addr != "" {
+			info["master_addr"] = addr
 		}
 		r, err := c.Do("CONFIG", "GET", "maxmemory")
 		if err != nil {
@@ -202,12 +537,62 @@ func (c *Client) InfoFull() (map[string]string, error) {
 	}
 }
 
+func (c *Client) ConfigGet(key string) (string, error) {
+	r, err := c.Do("CONFIG", "GET", key)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	p, err := redigo.Strings(r, nil)
+	if err != nil || len(p) != 2 {
+		return "", errors.Errorf("invalid response = %v", r)
+	}
+	return p[1], nil
+}
+
+// ConfigGetAll returns all config parameters, i.e. CONFIG GET *.
+func (c *Client) ConfigGetAll() (map[string]string, error) {
+	r, err := c.Do("CONFIG", "GET", "*")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	config, err := redigo.StringMap(r, nil)
+	if err != nil {
+		return nil, errors.Errorf("invalid response = %v", r)
+	}
+	return config, nil
+}
+
+func (c *Client) MaxMemoryPolicy() (string, error) {
+	return c.ConfigGet("maxmemory-policy")
+}
+
+func (c *Client) ConfigSet(key, value string) error {
+	reply, err := c.Do("CONFIG", "SET", key, value)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s, err := redigo.String(reply, nil); err != nil || s != "OK" {
+		return errors.Errorf("config set %s failed, invalid response = %v", key, reply)
+	}
+	return nil
+}
+
+func (c *Client) SetMaxMemory(bytes int64) error {
+	return c.ConfigSet("maxmemory", strconv.FormatInt(bytes, 10))
+}
+
 func (c *Client) SetMaster(master string) error {
+	if network, _ := dialNetwork(master); network != "tcp" {
+		return errors.Errorf("invalid master = %s, replication requires a tcp address", master)
+	}
 	host, port, err := net.SplitHostPort(master)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	c.Send("MULTI")
+	if c.User != "" {
+		c.Send("CONFIG", "SET", "masteruser", c.User)
+	}
 	c.Send("CONFIG", "SET", "masterauth", c.Auth)
 	c.Send("SLAVEOF", host, port)
 	c.Send("CONFIG", "REWRITE")
@@ -225,20 +610,130 @@ func (c *Client) SetMaster(master string) error {
 }
 
 func (c *Client) MigrateSlot(slot int, target string) (int, error) {
+	return c.MigrateSlotContext(context.Background(), slot, target)
+}
+
+func (c *Client) MigrateSlotContext(ctx context.Context, slot int, target string) (int, error) {
+	_, n, err := c.migrateSlot(ctx, "SLOTSMGRTTAGSLOT", slot, target)
+	return n, err
+}
+
+// MigrateSlotNoTag moves a single random key of the slot per call using
+// SLOTSMGRTSLOT, keys sharing the same hash tag are not moved together as
+// MigrateSlot does, which makes each call cheaper but keeps tagged keys
+// split across two groups until the slot is drained.
+func (c *Client) MigrateSlotNoTag(slot int, target string) (int, error) {
+	_, n, err := c.migrateSlot(context.Background(), "SLOTSMGRTSLOT", slot, target)
+	return n, err
+}
+
+func (c *Client) migrateSlot(ctx context.Context, cmd string, slot int, target string) (int, int, error) {
+	if err := validateSlot(slot); err != nil {
+		return 0, 0, err
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	mseconds := c.migrateTimeout()
+	if reply, err := c.doContext(ctx, c.migrateReadTimeout(), cmd, host, port, mseconds, slot); err != nil {
+		return 0, 0, errors.Trace(err)
+	} else {
+		return parseMigrateReply(reply)
+	}
+}
+
+func parseMigrateReply(reply interface{}) (int, int, error) {
+	p, err := redigo.Ints(redigo.Values(reply, nil))
+	if err != nil || len(p) != 2 {
+		return 0, 0, errors.Errorf("invalid response = %v", reply)
+	}
+	return p[0], p[1], nil
+}
+
+// MigrateSlotRounds keeps migrating the slot until it is empty or maxRounds
+// calls have been made, maxRounds <= 0 means no limit. When numkeys > 0 each
+// round moves at most numkeys keys with SLOTSMGRTTAGSLOT-ASYNC, otherwise a
+// single tag is moved per round. It returns the total number of keys moved
+// and the number of rounds used.
+func (c *Client) MigrateSlotRounds(slot int, target string, maxRounds, numkeys int) (moved, rounds int, err error) {
+	for maxRounds <= 0 || rounds < maxRounds {
+		var n, remain int
+		if numkeys > 0 {
+			n, remain, err = c.migrateSlotAsync(context.Background(), slot, target, &MigrateSlotAsyncOption{
+				NumKeys: numkeys,
+				Timeout: time.Duration(c.migrateTimeout()) * time.Millisecond,
+			})
+		} else {
+			n, remain, err = c.migrateSlot(context.Background(), "SLOTSMGRTTAGSLOT", slot, target)
+		}
+		if err != nil {
+			return moved, rounds, err
+		}
+		moved, rounds = moved+n, rounds+1
+		if remain == 0 {
+			return moved, rounds, nil
+		}
+	}
+	return moved, rounds, errors.Errorf("slot-[%d] not drained after %d rounds", slot, rounds)
+}
+
+var ErrMigrateStalled = errors.New("slot migration makes no progress")
+
+func (c *Client) DrainSlot(slot int, target string, maxStalls int, progress func(remaining int)) error {
+	var last, stalls = -1, 0
+	for {
+		n, err := c.MigrateSlot(slot, target)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(n)
+		}
+		if n == 0 {
+			return nil
+		}
+		if last >= 0 && n >= last {
+			if stalls++; stalls >= math2.MaxInt(maxStalls, 1) {
+				return errors.Trace(ErrMigrateStalled)
+			}
+		} else {
+			stalls = 0
+		}
+		last = n
+	}
+}
+
+func (c *Client) MigrateTagOne(key string, target string) (int, error) {
 	host, port, err := net.SplitHostPort(target)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	mseconds := int(c.Timeout / time.Millisecond)
-	if reply, err := c.Do("SLOTSMGRTTAGSLOT", host, port, mseconds, slot); err != nil {
+	mseconds := c.migrateTimeout()
+	if reply, err := c.DoTimeout(c.migrateReadTimeout(), "SLOTSMGRTTAGONE", host, port, mseconds, key); err != nil {
 		return 0, errors.Trace(err)
 	} else {
-		p, err := redigo.Ints(redigo.Values(reply, nil))
-		if err != nil || len(p) != 2 {
+		n, err := redigo.Int(reply, nil)
+		if err != nil {
 			return 0, errors.Errorf("invalid response = %v", reply)
 		}
-		return p[1], nil
+		return n, nil
+	}
+}
+
+func (c *Client) migrateTimeout() int {
+	timeout := c.MigrateTimeout
+	if timeout == 0 {
+		timeout = c.Timeout
 	}
+	return int(timeout / time.Millisecond)
+}
+
+func (c *Client) migrateReadTimeout() time.Duration {
+	if c.Timeout != 0 && c.Timeout < c.MigrateTimeout {
+		return c.MigrateTimeout
+	}
+	return c.Timeout
 }
 
 type MigrateSlotAsyncOption struct {
@@ -249,185 +744,1681 @@ type MigrateSlotAsyncOption struct {
 }
 
 func (c *Client) MigrateSlotAsync(slot int, target string, option *MigrateSlotAsyncOption) (int, error) {
+	return c.MigrateSlotAsyncContext(context.Background(), slot, target, option)
+}
+
+func (c *Client) MigrateSlotAsyncContext(ctx context.Context, slot int, target string, option *MigrateSlotAsyncOption) (int, error) {
+	_, n, err := c.migrateSlotAsync(ctx, slot, target, option)
+	return n, err
+}
+
+func (c *Client) migrateSlotAsync(ctx context.Context, slot int, target string, option *MigrateSlotAsyncOption) (int, int, error) {
+	if err := validateSlot(slot); err != nil {
+		return 0, 0, err
+	}
 	host, port, err := net.SplitHostPort(target)
 	if err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	}
-	if reply, err := c.Do("SLOTSMGRTTAGSLOT-ASYNC", host, port, int(option.Timeout/time.Millisecond),
+	if reply, err := c.doContext(ctx, c.migrateReadTimeout(), "SLOTSMGRTTAGSLOT-ASYNC", host, port, int(option.Timeout/time.Millisecond),
 		option.MaxBulks, option.MaxBytes, slot, option.NumKeys); err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	} else {
-		p, err := redigo.Ints(redigo.Values(reply, nil))
-		if err != nil || len(p) != 2 {
-			return 0, errors.Errorf("invalid response = %v", reply)
-		}
-		return p[1], nil
+		return parseMigrateReply(reply)
 	}
 }
 
-func (c *Client) SlotsInfo() (map[int]int, error) {
-	if reply, err := c.Do("SLOTSINFO"); err != nil {
+// MigrateSlotWithLimit moves at most numkeys keys of the slot and returns
+// the number of keys still remaining in it, so callers can pace migration by
+// calling it repeatedly until it returns 0. SLOTSMGRTTAGSLOT takes no limit,
+// so it's built on the async command with server defaults for the buffers.
+func (c *Client) MigrateSlotWithLimit(slot int, target string, numkeys int) (int, error) {
+	if numkeys <= 0 {
+		return 0, errors.Errorf("invalid numkeys = %d", numkeys)
+	}
+	return c.MigrateSlotAsync(slot, target, &MigrateSlotAsyncOption{
+		NumKeys: numkeys,
+		Timeout: time.Duration(c.migrateTimeout()) * time.Millisecond,
+	})
+}
+
+type MigrateAsyncStatus struct {
+	Host string
+	Port int
+
+	Timeout      time.Duration
+	SinceLastUse time.Duration
+
+	SendingMsgs    int64
+	BlockedClients int64
+
+	// BatchKeys is the number of keys in the batch being migrated, not the
+	// keys left in the slot, use SlotsKeyCount for those.
+	BatchKeys    int64
+	EstimateMsgs int64
+	RemovedKeys  int64
+	ChunkedVals  int64
+}
+
+func (c *Client) MigrateSlotAsyncStatus() (*MigrateAsyncStatus, error) {
+	reply, err := c.Do("SLOTSMGRT-ASYNC-STATUS")
+	if err != nil {
 		return nil, errors.Trace(err)
-	} else {
-		infos, err := redigo.Values(reply, nil)
+	}
+	if reply == nil {
+		return nil, nil
+	}
+	values, err := redigo.Values(reply, nil)
+	if err != nil || len(values)%2 != 0 {
+		return nil, errors.Errorf("invalid response = %v", reply)
+	}
+	var status = &MigrateAsyncStatus{}
+	for i := 0; i < len(values); i += 2 {
+		key, err := redigo.String(values[i], nil)
 		if err != nil {
-			return nil, errors.Trace(err)
+			return nil, errors.Errorf("invalid response[%d] = %v", i, values[i])
 		}
-		slots := make(map[int]int)
-		for i, info := range infos {
-			p, err := redigo.Ints(info, nil)
-			if err != nil || len(p) != 2 {
-				return nil, errors.Errorf("invalid response[%d] = %v", i, info)
-			}
-			slots[p[0]] = p[1]
+		switch key {
+		case "host":
+			status.Host, err = redigo.String(values[i+1], nil)
+		case "port":
+			status.Port, err = redigo.Int(values[i+1], nil)
+		case "timeout":
+			var n int64
+			n, err = redigo.Int64(values[i+1], nil)
+			status.Timeout = time.Duration(n) * time.Millisecond
+		case "since_lastuse":
+			var n int64
+			n, err = redigo.Int64(values[i+1], nil)
+			status.SinceLastUse = time.Duration(n) * time.Millisecond
+		case "sending_msgs":
+			status.SendingMsgs, err = redigo.Int64(values[i+1], nil)
+		case "blocked_clients":
+			status.BlockedClients, err = redigo.Int64(values[i+1], nil)
+		case "batched_iterator":
+			err = status.parseBatchedIterator(values[i+1])
+		}
+		if err != nil {
+			return nil, errors.Errorf("invalid response[%d] = %v", i+1, values[i+1])
 		}
-		return slots, nil
 	}
+	return status, nil
 }
 
-func (c *Client) Role() (string, error) {
-	if reply, err := c.Do("ROLE"); err != nil {
-		return "", err
-	} else {
-		values, err := redigo.Values(reply, nil)
+var ErrUnsupported = errors.New("command is not supported by redis server")
+
+// MigrateAsyncRunning reports whether an async migration is in progress in
+// the current database. codis-server runs at most one at a time and keeps
+// no per-slot state, use MigrateSlotAsyncStatus to see where it's going.
+func (c *Client) MigrateAsyncRunning() (bool, error) {
+	status, err := c.MigrateSlotAsyncStatus()
+	if err != nil {
+		return false, unsupported(err)
+	}
+	return status != nil, nil
+}
+
+// MigrateSlotAsyncCancel aborts the running async migration, it returns
+// false if there's nothing to cancel.
+func (c *Client) MigrateSlotAsyncCancel() (bool, error) {
+	n, err := redigo.Int(c.Do("SLOTSMGRT-ASYNC-CANCEL"))
+	if err != nil {
+		return false, unsupported(err)
+	}
+	return n != 0, nil
+}
+
+func unsupported(err error) error {
+	if e, ok := errors.Cause(err).(redigo.Error); ok {
+		if strings.HasPrefix(string(e), "ERR unknown command") {
+			return errors.Trace(ErrUnsupported)
+		}
+	}
+	return errors.Trace(err)
+}
+
+func (s *MigrateAsyncStatus) parseBatchedIterator(reply interface{}) error {
+	if reply == nil {
+		return nil
+	}
+	values, err := redigo.Values(reply, nil)
+	if err != nil || len(values)%2 != 0 {
+		return errors.Errorf("invalid response = %v", reply)
+	}
+	for i := 0; i < len(values); i += 2 {
+		key, err := redigo.String(values[i], nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		switch key {
+		case "keys":
+			p, err := redigo.Values(values[i+1], nil)
+			if err != nil || len(p) != 2 {
+				return errors.Errorf("invalid response = %v", values[i+1])
+			}
+			s.BatchKeys, err = redigo.Int64(p[0], nil)
+		case "estimate_msgs":
+			s.EstimateMsgs, err = redigo.Int64(values[i+1], nil)
+		case "removed_keys":
+			s.RemovedKeys, err = redigo.Int64(values[i+1], nil)
+		case "chunked_vals":
+			s.ChunkedVals, err = redigo.Int64(values[i+1], nil)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) SlotsInfo() (map[int]int, error) {
+	return c.SlotsInfoContext(context.Background())
+}
+
+func (c *Client) SlotsInfoContext(ctx context.Context) (map[int]int, error) {
+	if reply, err := c.DoContext(ctx, "SLOTSINFO"); err != nil {
+		return nil, errors.Trace(err)
+	} else {
+		infos, err := redigo.Values(reply, nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		slots := make(map[int]int)
+		for i, info := range infos {
+			p, err := redigo.Ints(info, nil)
+			if err != nil || len(p) != 2 || validateSlot(p[0]) != nil {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, info)
+			}
+			slots[p[0]] = p[1]
+		}
+		return slots, nil
+	}
+}
+
+func (c *Client) SlotsKeyCount(slot int) (int64, error) {
+	if err := validateSlot(slot); err != nil {
+		return 0, err
+	}
+	if reply, err := c.Do("SLOTSINFO", slot, 1); err != nil {
+		return 0, errors.Trace(err)
+	} else {
+		infos, err := redigo.Values(reply, nil)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		for i, info := range infos {
+			p, err := redigo.Ints(info, nil)
+			if err != nil || len(p) != 2 {
+				return 0, errors.Errorf("invalid response[%d] = %v", i, info)
+			}
+			if p[0] == slot {
+				return int64(p[1]), nil
+			}
+		}
+		return 0, nil
+	}
+}
+
+func (c *Client) SlotsKeysEmpty(slot int) (bool, error) {
+	n, err := c.SlotsKeyCount(slot)
+	if err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+func (c *Client) TotalKeys() (int64, error) {
+	slots, err := c.SlotsInfo()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, n := range slots {
+		total += int64(n)
+	}
+	return total, nil
+}
+
+func (c *Client) DBSize() (int64, error) {
+	n, err := redigo.Int64(c.Do("DBSIZE"))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return n, nil
+}
+
+// SlotsDel deletes all keys of the slots and returns the number of keys
+// removed from each slot. SLOTSDEL itself replies with the keys remaining,
+// so the slots are counted with SLOTSINFO first, keys written in between are
+// not taken into account.
+func (c *Client) SlotsDel(slots ...int) (map[int]int, error) {
+	if len(slots) == 0 {
+		return map[int]int{}, nil
+	}
+	var args = make([]interface{}, len(slots))
+	for i, slot := range slots {
+		if err := validateSlot(slot); err != nil {
+			return nil, err
+		}
+		args[i] = slot
+	}
+	var before = make(map[int]int)
+	for _, slot := range slots {
+		n, err := c.SlotsKeyCount(slot)
+		if err != nil {
+			return nil, err
+		}
+		before[slot] = int(n)
+	}
+	if reply, err := c.Do("SLOTSDEL", args...); err != nil {
+		return nil, errors.Trace(err)
+	} else {
+		infos, err := redigo.Values(reply, nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		removed := make(map[int]int)
+		for i, info := range infos {
+			p, err := redigo.Ints(info, nil)
+			if err != nil || len(p) != 2 {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, info)
+			}
+			removed[p[0]] = math2.MaxInt(before[p[0]]-p[1], 0)
+		}
+		return removed, nil
+	}
+}
+
+// SlotEntry is a key serialized by DUMP, TTL 0 means no expire.
+type SlotEntry struct {
+	Key   []byte
+	TTL   time.Duration
+	Value []byte
+}
+
+// SlotsRestore restores the entries with SLOTSRESTORE, existing keys are
+// replaced.
+func (c *Client) SlotsRestore(entries ...*SlotEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var args = make([]interface{}, 0, len(entries)*3)
+	for _, e := range entries {
+		args = append(args, e.Key, int64(e.TTL/time.Millisecond), e.Value)
+	}
+	if _, err := redigo.String(c.Do("SLOTSRESTORE", args...)); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (c *Client) SlotsScan(slot int, cursor int, count int) (int, [][]byte, error) {
+	if err := validateSlot(slot); err != nil {
+		return 0, nil, err
+	}
+	var args = []interface{}{slot, cursor}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	return c.scan("SLOTSSCAN", args...)
+}
+
+// Scan runs SCAN over the whole keyspace, match and count are only sent if
+// they are not empty or 0.
+func (c *Client) Scan(cursor int, match string, count int) (int, [][]byte, error) {
+	var args = []interface{}{cursor}
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	return c.scan("SCAN", args...)
+}
+
+func (c *Client) scan(cmd string, args ...interface{}) (int, [][]byte, error) {
+	if reply, err := c.Do(cmd, args...); err != nil {
+		return 0, nil, errors.Trace(err)
+	} else {
+		p, err := redigo.Values(reply, nil)
+		if err != nil || len(p) != 2 {
+			return 0, nil, errors.Errorf("invalid response = %v", reply)
+		}
+		next, err := redigo.Int(p[0], nil)
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid response[0] = %v", p[0])
+		}
+		keys, err := redigo.ByteSlices(p[1], nil)
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid response[1] = %v", p[1])
+		}
+		return next, keys, nil
+	}
+}
+
+// SlotMemoryEstimate estimates the memory used by the slot in bytes. It
+// scans up to samples keys of the slot with SLOTSSCAN, runs MEMORY USAGE on
+// each of them and scales the average by SlotsKeyCount. That costs about
+// samples+2 round trips, MEMORY USAGE is O(1) for strings but samples the
+// nested values of big aggregates, so the result is only a rough hint.
+// Servers without MEMORY USAGE are measured by KeyMemoryUsage's fallback.
+func (c *Client) SlotMemoryEstimate(slot int, samples int) (int64, error) {
+	total, err := c.SlotsKeyCount(slot)
+	if err != nil || total == 0 {
+		return 0, err
+	}
+	keys, err := c.SlotsSample(slot, samples)
+	if err != nil {
+		return 0, err
+	}
+
+	var used, found int64
+	for _, key := range keys {
+		n, err := c.KeyMemoryUsage(key)
+		switch {
+		case errors.Equal(err, ErrNoSuchKey):
+			continue
+		case err != nil:
+			return 0, err
+		}
+		used, found = used+n, found+1
+	}
+	if found == 0 {
+		return 0, nil
+	}
+	return used * total / found, nil
+}
+
+// SlotsSample returns up to count keys of the slot, it calls SLOTSSCAN until
+// enough keys are collected or the cursor returns to 0. Each call asks for
+// at most 100 keys, so that the server is never blocked for long.
+func (c *Client) SlotsSample(slot int, count int) ([]string, error) {
+	count = math2.MaxInt(count, 1)
+
+	var keys []string
+	for cursor := 0; len(keys) < count; {
+		next, batch, err := c.SlotsScan(slot, cursor, math2.MinInt(count-len(keys), 100))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			keys = append(keys, string(key))
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys, nil
+}
+
+var ErrNoSuchKey = errors.New("no such key")
+
+// KeyMemoryUsage returns MEMORY USAGE of the key in bytes, ErrNoSuchKey is
+// returned if the key doesn't exist. Servers older than 4.0, codis-server
+// 3.2 included, have no MEMORY, so it falls back to the serializedlength of
+// DEBUG OBJECT, which is the size of the key's RDB encoding rather than its
+// memory and usually much smaller for compressible or small values.
+func (c *Client) KeyMemoryUsage(key string) (int64, error) {
+	n, err := redigo.Int64(c.Do("MEMORY", "USAGE", key))
+	switch {
+	case err == redigo.ErrNil:
+		return 0, errors.Trace(ErrNoSuchKey)
+	case err != nil:
+		if err := unsupported(err); !errors.Equal(err, ErrUnsupported) {
+			return 0, err
+		}
+		return c.keySerializedLength(key)
+	}
+	return n, nil
+}
+
+func (c *Client) keySerializedLength(key string) (int64, error) {
+	s, err := redigo.String(c.Do("DEBUG", "OBJECT", key))
+	if err != nil {
+		if err := unsupported(err); errors.Equal(err, ErrUnsupported) {
+			return 0, err
+		}
+		return 0, objectError(err)
+	}
+	for _, field := range strings.Fields(s) {
+		if v := strings.TrimPrefix(field, "serializedlength:"); v != field {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, errors.Errorf("invalid response = %q", s)
+			}
+			return n, nil
+		}
+	}
+	return 0, errors.Errorf("invalid response = %q", s)
+}
+
+// ObjectEncoding returns OBJECT ENCODING of the key, e.g. ziplist.
+func (c *Client) ObjectEncoding(key string) (string, error) {
+	s, err := redigo.String(c.Do("OBJECT", "ENCODING", key))
+	if err != nil {
+		return "", objectError(err)
+	}
+	return s, nil
+}
+
+// ObjectIdleTime returns OBJECT IDLETIME of the key in seconds.
+func (c *Client) ObjectIdleTime(key string) (int64, error) {
+	n, err := redigo.Int64(c.Do("OBJECT", "IDLETIME", key))
+	if err != nil {
+		return 0, objectError(err)
+	}
+	return n, nil
+}
+
+// objectError maps both a nil reply and "ERR no such key", which newer
+// servers reply to OBJECT for a missing key, to ErrNoSuchKey.
+func objectError(err error) error {
+	if err == redigo.ErrNil {
+		return errors.Trace(ErrNoSuchKey)
+	}
+	if e, ok := errors.Cause(err).(redigo.Error); ok && strings.Contains(string(e), "no such key") {
+		return errors.Trace(ErrNoSuchKey)
+	}
+	return errors.Trace(err)
+}
+
+type KeyUsage struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SlotBiggestKeys samples up to samples keys of the slot like
+// SlotMemoryEstimate and returns the n largest ones by MEMORY USAGE in
+// descending order. Keys outside the sample are not considered, so it's a
+// hint of the hot keys rather than an exact answer.
+func (c *Client) SlotBiggestKeys(slot int, samples, n int) ([]*KeyUsage, error) {
+	keys, err := c.SlotsSample(slot, samples)
+	if err != nil {
+		return nil, err
+	}
+	var usages []*KeyUsage
+	for _, key := range keys {
+		bytes, err := c.KeyMemoryUsage(key)
+		switch {
+		case errors.Equal(err, ErrNoSuchKey):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		usages = append(usages, &KeyUsage{Key: key, Bytes: bytes})
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].Bytes > usages[j].Bytes
+	})
+	if n > 0 && len(usages) > n {
+		usages = usages[:n]
+	}
+	return usages, nil
+}
+
+func (c *Client) Role() (string, error) {
+	if reply, err := c.Do("ROLE"); err != nil {
+		return "", err
+	} else {
+		values, err := redigo.Values(reply, nil)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		if len(values) == 0 {
+			return "", errors.Errorf("invalid response = %v", reply)
+		}
+		role, err := redigo.String(values[0], nil)
+		if err != nil {
+			return "", errors.Errorf("invalid response[0] = %v", values[0])
+		}
+		return strings.ToUpper(role), nil
+	}
+}
+
+func (c *Client) ShutdownSave(save bool) error {
+	if save {
+		return c.shutdown("SAVE")
+	}
+	return c.shutdown("NOSAVE")
+}
+
+// shutdown stops the server. On success the server closes the connection
+// without a reply, so only io.EOF is taken as success, a timeout or any
+// other error is returned as is. The client is closed and will never be
+// recycled by a pool afterwards.
+func (c *Client) shutdown(args ...interface{}) error {
+	_, err := c.Do("SHUTDOWN", args...)
+	switch errors.Cause(err) {
+	case io.EOF, io.ErrUnexpectedEOF:
+		c.Close()
+		return nil
+	case nil:
+		return errors.Errorf("shutdown returned without closing the connection")
+	}
+	return errors.Trace(err)
+}
+
+var ErrFlushNotConfirmed = errors.New("flush is not confirmed")
+
+// FlushOption must be passed with Confirm set, so that FLUSHDB & FLUSHALL
+// can never be issued by accident. ASYNC is sent only if Async is set,
+// since servers older than 4.0 reject it.
+type FlushOption struct {
+	Async   bool
+	Confirm bool
+}
+
+func (c *Client) FlushDB(option *FlushOption) error {
+	return c.flush("FLUSHDB", option)
+}
+
+func (c *Client) FlushAll(option *FlushOption) error {
+	return c.flush("FLUSHALL", option)
+}
+
+func (c *Client) flush(cmd string, option *FlushOption) error {
+	if option == nil || !option.Confirm {
+		return errors.Trace(ErrFlushNotConfirmed)
+	}
+	var args []interface{}
+	if option.Async {
+		args = append(args, "ASYNC")
+	}
+	if _, err := c.Do(cmd, args...); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+var ErrDebugDisabled = errors.New("debug commands are disabled")
+
+var debugEnabled atomic2.Bool
+
+// EnableDebugCommands allows DebugSleep to be sent, it's off by default and
+// is meant for fault-injection tests only, never for production.
+func EnableDebugCommands(enabled bool) {
+	debugEnabled.Set(enabled)
+}
+
+// DebugSleep blocks the server for the given seconds with DEBUG SLEEP. It's
+// a testing-only primitive and fails with ErrDebugDisabled unless
+// EnableDebugCommands(true) has been called. The client waits for the reply
+// up to the sleep plus its own Timeout.
+func (c *Client) DebugSleep(seconds float64) error {
+	if !debugEnabled.IsTrue() {
+		return errors.Trace(ErrDebugDisabled)
+	}
+	var timeout time.Duration
+	if c.Timeout != 0 {
+		timeout = c.Timeout + time.Duration(seconds*float64(time.Second))
+	}
+	if _, err := c.DoTimeout(timeout, "DEBUG", "SLEEP", strconv.FormatFloat(seconds, 'f', -1, 64)); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (c *Client) BgSave() error {
+	if _, err := redigo.String(c.Do("BGSAVE")); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (c *Client) LastSave() (time.Time, error) {
+	n, err := redigo.Int64(c.Do("LASTSAVE"))
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return time.Unix(n, 0), nil
+}
+
+func (c *Client) RdbSaveInProgress() (bool, error) {
+	info, err := c.InfoSection("persistence")
+	if err != nil {
+		return false, err
+	}
+	v, ok := info["rdb_bgsave_in_progress"]
+	if !ok {
+		return false, errors.Errorf("missing rdb_bgsave_in_progress")
+	}
+	return v == "1", nil
+}
+
+// WaitForBgSave polls every poll interval until no bgsave is in progress and
+// LASTSAVE is after since, or ctx is done. Callers should take since from
+// LastSave before calling BgSave.
+func (c *Client) WaitForBgSave(ctx context.Context, since time.Time, poll time.Duration) error {
+	for {
+		running, err := c.RdbSaveInProgress()
+		if err != nil {
+			return err
+		}
+		if !running {
+			t, err := c.LastSave()
+			if err != nil {
+				return err
+			}
+			if t.After(since) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(poll):
+		}
+	}
+}
+
+type SlowLogEntry struct {
+	Id           int64    `json:"id"`
+	Timestamp    int64    `json:"timestamp"`
+	MicroSeconds int64    `json:"microseconds"`
+	Command      []string `json:"command"`
+	ClientAddr   string   `json:"client_addr,omitempty"`
+	ClientName   string   `json:"client_name,omitempty"`
+}
+
+func (c *Client) SlowLog(count int) ([]*SlowLogEntry, error) {
+	if reply, err := c.Do("SLOWLOG", "GET", count); err != nil {
+		return nil, errors.Trace(err)
+	} else {
+		values, err := redigo.Values(reply, nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var entries = make([]*SlowLogEntry, 0, len(values))
+		for i, value := range values {
+			fields, err := redigo.Values(value, nil)
+			if err != nil || len(fields) < 4 {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, value)
+			}
+			var e = &SlowLogEntry{}
+			if e.Id, err = redigo.Int64(fields[0], nil); err != nil {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, value)
+			}
+			if e.Timestamp, err = redigo.Int64(fields[1], nil); err != nil {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, value)
+			}
+			if e.MicroSeconds, err = redigo.Int64(fields[2], nil); err != nil {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, value)
+			}
+			if e.Command, err = redigo.Strings(fields[3], nil); err != nil {
+				return nil, errors.Errorf("invalid response[%d] = %v", i, value)
+			}
+			if len(fields) >= 6 {
+				e.ClientAddr, _ = redigo.String(fields[4], nil)
+				e.ClientName, _ = redigo.String(fields[5], nil)
+			}
+			entries = append(entries, e)
+		}
+		return entries, nil
+	}
+}
+
+func (c *Client) SlowLogReset() error {
+	if _, err := c.Do("SLOWLOG", "RESET"); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+var (
+	ErrClosedPool    = errors.New("use of closed redis pool")
+	ErrPoolExhausted = errors.New("too many active clients in redis pool")
+
+	ErrBackendUnavailable = errors.New("redis backend is unavailable")
+)
+
+type Pool struct {
+	mu sync.Mutex
+
+	pool map[string]*list.List
+
+	option  ClientOption
+	maxIdle int
+
+	auths map[string]string
+
+	onEvict func(addr string, reason string)
+
+	maxLifetime time.Duration
+
+	validate atomic2.Bool
+	noReuse  atomic2.Bool
+
+	active struct {
+		limit   int
+		timeout time.Duration
+		count   map[string]int
+		wait    chan struct{}
+	}
+
+	retry struct {
+		attempts int
+		delay    time.Duration
+	}
+
+	stats struct {
+		hits, misses, dials atomic2.Int64
+
+		created      atomic2.Int64
+		dialFailures atomic2.Int64
+
+		closed          atomic2.Int64
+		closedOnError   atomic2.Int64
+		closedOnExpire  atomic2.Int64
+		closedOnCleanup atomic2.Int64
+	}
+
+	cleanup struct {
+		C chan struct{}
+
+		ping time.Duration
+	}
+
+	exit struct {
+		C chan struct{}
+	}
+
+	breaker struct {
+		threshold int
+		window    time.Duration
+		cooldown  time.Duration
+		data      map[string]*poolBreaker
+	}
+
+	roles struct {
+		ttl  time.Duration
+		data map[string]*poolRole
+		next atomic2.Int64
+	}
+
+	latency struct {
+		data map[string]*poolLatency
+	}
+
+	inuse int
+
+	closed bool
+}
+
+func NewPool(auth string, timeout time.Duration) *Pool {
+	return NewPoolWithUser("", auth, timeout)
+}
+
+func NewPoolWithUser(user, auth string, timeout time.Duration) *Pool {
+	p := &Pool{
+		pool: make(map[string]*list.List),
+
+		auths: make(map[string]string),
+	}
+	p.active.count = make(map[string]int)
+	p.breaker.data = make(map[string]*poolBreaker)
+	p.roles.ttl = DefaultRoleTTL
+	p.roles.data = make(map[string]*poolRole)
+	p.latency.data = make(map[string]*poolLatency)
+	p.option.User = user
+	p.option.Auth = auth
+	p.option.Timeout = timeout
+	p.option.KeepAlive = DefaultKeepAlive
+	p.exit.C = make(chan struct{})
+
+	if timeout != 0 {
+		p.StartCleanup(time.Minute)
+	}
+
+	return p
+}
+
+func (p *Pool) StartCleanup(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startCleanup(interval)
+}
+
+// StartReaper starts the cleanup goroutine like StartCleanup and returns a
+// function that stops it. The goroutine exits when the pool is closed, but
+// callers should still call stop() during shutdown if the pool outlives them.
+// The stop function is a no-op once another reaper has replaced this one.
+func (p *Pool) StartReaper(interval time.Duration) (stop func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.startCleanup(interval)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if c != nil && p.cleanup.C == c {
+			p.stopCleanup()
+		}
+	}
+}
+
+func (p *Pool) startCleanup(interval time.Duration) chan struct{} {
+	if p.closed {
+		return nil
+	}
+	p.stopCleanup()
+
+	if interval <= 0 {
+		return nil
+	}
+	var stop = make(chan struct{})
+	p.cleanup.C = stop
+
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.exit.C:
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.Cleanup()
+			}
+		}
+	}()
+	return stop
+}
+
+func (p *Pool) StopCleanup() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopCleanup()
+}
+
+func (p *Pool) stopCleanup() {
+	if p.cleanup.C != nil {
+		close(p.cleanup.C)
+		p.cleanup.C = nil
+	}
+}
+
+func (p *Pool) SetMaxIdle(maxIdle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxIdle = math2.MaxInt(maxIdle, 0)
+}
+
+// SetMaxLifetime retires clients older than maxLifetime when they're
+// returned to or taken from the pool, 0 means unlimited.
+func (p *Pool) SetMaxLifetime(maxLifetime time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxLifetime = math2.MaxDuration(maxLifetime, 0)
+}
+
+func (p *Pool) SetMaxActive(maxActive int, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active.limit = math2.MaxInt(maxActive, 0)
+	p.active.timeout = timeout
+	p.notify()
+}
+
+func (p *Pool) SetValidateOnBorrow(enabled bool) {
+	p.validate.Set(enabled)
+}
+
+// SetPingOnCleanup makes Cleanup send PING to every idle client and close
+// the ones not replying within timeout, 0 disables it (the default).
+func (p *Pool) SetPingOnCleanup(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanup.ping = math2.MaxDuration(timeout, 0)
+}
+
+// SetNoReuse turns the pool into pass-through mode, every GetClient dials a
+// new client and PutClient always closes it. It's meant for debugging.
+func (p *Pool) SetNoReuse(enabled bool) {
+	p.noReuse.Set(enabled)
+}
+
+// SetAuth changes the credentials used for new clients and closes all idle
+// clients, clients in use keep the old credentials and are closed once put
+// back. The pool doesn't touch the servers, callers must rotate the password
+// there (e.g. keep both valid via ACL) before calling it.
+func (p *Pool) SetAuth(user, auth string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.User = user
+	p.option.Auth = auth
+
+	for addr := range p.pool {
+		p.closeIdle(addr, EvictAuth)
+	}
+}
+
+// SetAddrAuth overrides the password used for addr only, e.g. while rolling
+// a new password across the cluster one server at a time. Like SetAuth, idle
+// clients of addr are closed and clients in use are closed once put back.
+func (p *Pool) SetAddrAuth(addr string, auth string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.auths[addr] = auth
+	p.closeIdle(addr, EvictAuth)
+}
+
+// ResetAddrAuth drops the override set by SetAddrAuth, addr falls back to
+// the pool's password.
+func (p *Pool) ResetAddrAuth(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.auths[addr]; ok {
+		delete(p.auths, addr)
+		p.closeIdle(addr, EvictAuth)
+	}
+}
+
+func (p *Pool) authOf(addr string) string {
+	if auth, ok := p.auths[addr]; ok {
+		return auth
+	}
+	return p.option.Auth
+}
+
+// SetDatabase makes new clients select the database, idle clients are
+// closed. Clients put back with another database selected are switched
+// back to it by PutClient.
+func (p *Pool) SetDatabase(database int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.Database = database
+
+	for addr := range p.pool {
+		p.closeIdle(addr, EvictDatabase)
+	}
+}
+
+func (p *Pool) SetTLS(enabled bool, config *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.TLSEnabled = enabled
+	p.option.TLSConfig = config
+}
+
+const DefaultKeepAlive = time.Second * 30
+
+func (p *Pool) SetTCPOptions(keepAlive time.Duration, noDelay bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.KeepAlive = keepAlive
+	p.option.DisableNoDelay = !noDelay
+}
+
+func (p *Pool) SetDialTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.DialTimeout = timeout
+}
+
+// SetMigrateTimeout sets the timeout passed to SLOTSMGRTTAGSLOT by clients
+// dialed from now on, it's sent to codis-server in milliseconds.
+func (p *Pool) SetMigrateTimeout(timeout time.Duration) error {
+	if timeout < time.Millisecond {
+		return errors.Errorf("invalid migrate timeout = %v", timeout)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.option.MigrateTimeout = timeout
+	return nil
+}
+
+func (p *Pool) SetDialRetry(attempts int, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retry.attempts = math2.MaxInt(attempts, 0)
+	p.retry.delay = delay
+}
+
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.stopCleanup()
+	close(p.exit.C)
+
+	for addr := range p.pool {
+		p.closeIdle(addr, EvictClosed)
+	}
+	return nil
+}
+
+func (p *Pool) closeIdle(addr string, reason string) {
+	if list := p.pool[addr]; list != nil {
+		for i := list.Len(); i != 0; i-- {
+			c := list.Remove(list.Front()).(*Client)
+			p.closeClient(c, reason)
+		}
+		delete(p.pool, addr)
+	}
+}
+
+// Remove closes and drops all idle clients of addr, it's a no-op for an
+// unknown addr. Clients in use are not affected.
+func (p *Pool) Remove(addr string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosedPool
+	}
+	p.closeIdle(addr, EvictRemoved)
+	delete(p.roles.data, addr)
+	delete(p.latency.data, addr)
+	return nil
+}
+
+// Drain waits until every client handed out by GetClient has been put back,
+// then closes the pool. The pool is closed anyway if ctx is done first.
+func (p *Pool) Drain(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		if p.closed || p.inuse == 0 {
+			p.mu.Unlock()
+			return p.Close()
+		}
+		if p.active.wait == nil {
+			p.active.wait = make(chan struct{})
+		}
+		wait := p.active.wait
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return errors.Trace(ctx.Err())
+		case <-wait:
+		}
+	}
+}
+
+func (p *Pool) Cleanup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosedPool
+	}
+
+	var probes []*Client
+	for addr, list := range p.pool {
+		for i := list.Len(); i != 0; i-- {
+			c := list.Remove(list.Front()).(*Client)
+			switch reason := p.evictReason(c); {
+			case reason != "":
+				p.closeClient(c, reason)
+				p.stats.closedOnCleanup.Incr()
+			case p.cleanup.ping != 0:
+				probes = append(probes, c)
+			default:
+				list.PushBack(c)
+			}
+		}
+		if list.Len() == 0 {
+			delete(p.pool, addr)
+		}
+	}
+	if len(probes) != 0 {
+		timeout := p.cleanup.ping
+		p.mu.Unlock()
+		alive := probeClients(probes, timeout)
+		p.mu.Lock()
+		for i, c := range probes {
+			if !alive[i] || p.closed {
+				p.closeClient(c, EvictPing)
+				if !p.closed {
+					p.stats.closedOnCleanup.Incr()
+				}
+				continue
+			}
+			cache := p.pool[c.Addr]
+			if cache == nil {
+				cache = list.New()
+				p.pool[c.Addr] = cache
+			}
+			if p.maxIdle != 0 && cache.Len() >= p.maxIdle {
+				p.closeClient(c, EvictMaxIdle)
+			} else {
+				cache.PushBack(c)
+				p.notify()
+			}
+		}
+	}
+	return nil
+}
+
+// probeClients pings the clients in parallel, Cleanup calls it without
+// holding p.mu, the clients have been taken out of the idle lists so nobody
+// else can use them meanwhile.
+func probeClients(clients []*Client, timeout time.Duration) []bool {
+	var alive = make([]bool, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			_, err := c.DoTimeout(timeout, "PING")
+			alive[i] = err == nil
+		}(i, c)
+	}
+	wg.Wait()
+	return alive
+}
+
+func (p *Pool) GetClient(addr string) (*Client, error) {
+	return p.getClientContext(context.Background(), addr)
+}
+
+// getClientContext is GetClient, but it gives up waiting for a free client
+// under SetMaxActive once ctx is done. The client is counted as in use by
+// tryGetClientFromCache while p.mu is held, so Drain never misses it.
+func (p *Pool) getClientContext(ctx context.Context, addr string) (*Client, error) {
+	for {
+		c, err := p.getClientFromCache(ctx, addr)
 		if err != nil {
-			return "", errors.Trace(err)
+			return nil, err
 		}
-		if len(values) == 0 {
-			return "", errors.Errorf("invalid response = %v", reply)
+		if c == nil {
+			break
 		}
-		role, err := redigo.String(values[0], nil)
-		if err != nil {
-			return "", errors.Errorf("invalid response[0] = %v", values[0])
+		if !p.validate.IsTrue() || c.Ping() == nil {
+			return c, nil
 		}
-		return strings.ToUpper(role), nil
+		p.mu.Lock()
+		p.inuse--
+		p.closeClient(c, EvictPing)
+		p.stats.closedOnError.Incr()
+		p.mu.Unlock()
 	}
+	p.stats.misses.Incr()
+	c, err := p.dialClient(addr)
+	if err != nil {
+		p.mu.Lock()
+		p.inuse--
+		p.release(addr)
+		p.mu.Unlock()
+	}
+	return c, err
 }
 
-var ErrClosedPool = errors.New("use of closed redis pool")
+func (p *Pool) dialClient(addr string) (*Client, error) {
+	p.mu.Lock()
+	option := p.option
+	option.Auth = p.authOf(addr)
+	attempts, delay := p.retry.attempts, p.retry.delay
+	p.mu.Unlock()
 
-type Pool struct {
-	mu sync.Mutex
+	for i := 1; ; i++ {
+		if err := p.breakerAllow(addr); err != nil {
+			return nil, err
+		}
+		p.stats.dials.Incr()
+		c, err := NewClientWithOption(addr, &option)
+		p.breakerDone(addr, err)
+		if err == nil {
+			p.stats.created.Incr()
+			p.recordLatency(addr, c.DialLatency)
+			return c, nil
+		}
+		p.stats.dialFailures.Incr()
+		if i >= attempts || errors.Equal(err, ErrRedisAuth) {
+			return nil, err
+		}
+		if err := p.backoff(delay, i); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	auth string
-	pool map[string]*list.List
+const poolLatencySamples = 64
 
-	timeout time.Duration
+// poolLatency keeps the dial latencies of the last poolLatencySamples
+// successful dials in a ring, percentiles are computed in Stats only.
+type poolLatency struct {
+	samples [poolLatencySamples]time.Duration
+	n       int
+}
 
-	exit struct {
-		C chan struct{}
+func (p *Pool) recordLatency(addr string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := p.latency.data[addr]
+	if l == nil {
+		l = &poolLatency{}
+		p.latency.data[addr] = l
 	}
-
-	closed bool
+	l.samples[l.n%poolLatencySamples] = d
+	l.n++
 }
 
-func NewPool(auth string, timeout time.Duration) *Pool {
-	p := &Pool{
-		auth: auth, timeout: timeout,
-		pool: make(map[string]*list.List),
+func (l *poolLatency) stats() *DialLatencyStats {
+	var samples = make([]time.Duration, math2.MinInt(l.n, poolLatencySamples))
+	copy(samples, l.samples[:])
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	var percentile = func(q int) int64 {
+		return int64(samples[(len(samples)-1)*q/100] / time.Microsecond)
 	}
-	p.exit.C = make(chan struct{})
+	return &DialLatencyStats{
+		Samples: len(samples),
+		P50:     percentile(50),
+		P95:     percentile(95),
+		Max:     percentile(100),
+	}
+}
 
-	if timeout != 0 {
-		go func() {
-			var ticker = time.NewTicker(time.Minute)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-p.exit.C:
-					return
-				case <-ticker.C:
-					p.Cleanup()
-				}
-			}
-		}()
+const (
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+type poolBreaker struct {
+	failures int
+	since    time.Time
+
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *poolBreaker) state() string {
+	switch {
+	case b.openUntil.IsZero():
+		return ""
+	case time.Now().Before(b.openUntil):
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
 	}
+}
 
-	return p
+// SetCircuitBreaker makes GetClient fail fast with ErrBackendUnavailable for
+// cooldown, once dialing addr has failed (or timed out on AUTH) threshold
+// times in a row within window. After cooldown a single dial is let through
+// as a probe, the breaker is closed if it succeeds. Threshold 0 disables the
+// breaker.
+func (p *Pool) SetCircuitBreaker(threshold int, window, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.breaker.threshold = math2.MaxInt(threshold, 0)
+	p.breaker.window = window
+	p.breaker.cooldown = cooldown
+	p.breaker.data = make(map[string]*poolBreaker)
 }
 
-func (p *Pool) Close() error {
+func (p *Pool) breakerAllow(addr string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.closed {
+	b := p.breaker.data[addr]
+	if b == nil {
 		return nil
 	}
-	p.closed = true
-	close(p.exit.C)
-
-	for addr, list := range p.pool {
-		for i := list.Len(); i != 0; i-- {
-			c := list.Remove(list.Front()).(*Client)
-			c.Close()
+	switch b.state() {
+	case BreakerOpen:
+		return errors.Trace(ErrBackendUnavailable)
+	case BreakerHalfOpen:
+		if b.probing {
+			return errors.Trace(ErrBackendUnavailable)
 		}
-		delete(p.pool, addr)
+		b.probing = true
 	}
 	return nil
 }
 
-func (p *Pool) Cleanup() error {
+func (p *Pool) breakerDone(addr string, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.closed {
+	if p.breaker.threshold == 0 {
+		return
+	}
+	switch {
+	case err == nil:
+		delete(p.breaker.data, addr)
+		return
+	case !errors.Equal(err, ErrRedisDial) && !errors.Equal(err, ErrRedisAuthTimeout):
+		if b := p.breaker.data[addr]; b != nil {
+			b.probing = false
+		}
+		return
+	}
+	b := p.breaker.data[addr]
+	if b == nil {
+		b = &poolBreaker{}
+		p.breaker.data[addr] = b
+	}
+	var now = time.Now()
+	if b.failures == 0 || (p.breaker.window != 0 && now.Sub(b.since) > p.breaker.window) {
+		b.failures, b.since = 0, now
+	}
+	if b.failures++; b.failures >= p.breaker.threshold || b.probing {
+		b.failures = 0
+		b.openUntil = now.Add(p.breaker.cooldown)
+		b.probing = false
+	}
+}
+
+// backoff sleeps for the i-th retry with jittered exponential delay, it
+// returns ErrClosedPool if the pool is closed meanwhile.
+func (p *Pool) backoff(delay time.Duration, i int) error {
+	backoff := delay << uint(i-1)
+	if backoff > 0 {
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+	select {
+	case <-p.exit.C:
 		return ErrClosedPool
+	case <-time.After(backoff):
+		return nil
 	}
+}
 
-	for addr, list := range p.pool {
-		for i := list.Len(); i != 0; i-- {
-			c := list.Remove(list.Front()).(*Client)
-			if !c.isRecyclable() {
-				c.Close()
-			} else {
-				list.PushBack(c)
-			}
+// IsRetryable reports whether err is a transient failure, i.e. the dial
+// failed or the connection is broken or timed out. Errors replied by the
+// server, rejected AUTH included, are never retryable.
+func IsRetryable(err error) bool {
+	switch err := errors.Cause(err); err {
+	case nil:
+		return false
+	case ErrRedisDial, ErrRedisAuthTimeout, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	default:
+		_, ok := err.(net.Error)
+		return ok
+	}
+}
+
+// DoWithRetry calls fn with a client of addr, and retries up to attempts
+// times with a fresh client if fn fails with a retryable error.
+func (p *Pool) DoWithRetry(addr string, attempts int, delay time.Duration, fn func(c *Client) error) error {
+	for i := 1; ; i++ {
+		err := p.doOnce(addr, fn)
+		if err == nil || i >= attempts || !IsRetryable(err) {
+			return err
 		}
-		if list.Len() == 0 {
-			delete(p.pool, addr)
+		if err := p.backoff(delay, i); err != nil {
+			return err
 		}
 	}
-	return nil
 }
 
-func (p *Pool) GetClient(addr string) (*Client, error) {
-	c, err := p.getClientFromCache(addr)
-	if err != nil || c != nil {
-		return c, err
+func (p *Pool) doOnce(addr string, fn func(c *Client) error) error {
+	c, err := p.GetClient(addr)
+	if err != nil {
+		return err
+	}
+	defer p.PutClient(c)
+	return fn(c)
+}
+
+func (p *Pool) getClientFromCache(ctx context.Context, addr string) (*Client, error) {
+	p.mu.Lock()
+	timeout := p.active.timeout
+	p.mu.Unlock()
+
+	var expire <-chan time.Time
+	for {
+		c, wait, err := p.tryGetClientFromCache(addr)
+		if err != nil || wait == nil {
+			return c, err
+		}
+		if expire == nil && timeout != 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expire = timer.C
+		}
+		select {
+		case <-p.exit.C:
+			return nil, ErrClosedPool
+		case <-expire:
+			return nil, ErrPoolExhausted
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		case <-wait:
+		}
 	}
-	return NewClient(addr, p.auth, p.timeout)
 }
 
-func (p *Pool) getClientFromCache(addr string) (*Client, error) {
+func (p *Pool) tryGetClientFromCache(addr string) (*Client, <-chan struct{}, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.closed {
-		return nil, ErrClosedPool
+		return nil, nil, ErrClosedPool
 	}
-	if list := p.pool[addr]; list != nil {
+	if list := p.pool[addr]; list != nil && !p.noReuse.IsTrue() {
 		for i := list.Len(); i != 0; i-- {
 			c := list.Remove(list.Front()).(*Client)
-			if !c.isRecyclable() {
-				c.Close()
+			if reason := p.evictReason(c); reason != "" {
+				p.closeClient(c, reason)
+				p.countEvicted(reason)
 			} else {
-				return c, nil
+				p.stats.hits.Incr()
+				p.inuse++
+				return c, nil, nil
 			}
 		}
 	}
-	return nil, nil
+	if p.active.limit != 0 && p.active.count[addr] >= p.active.limit {
+		if p.active.wait == nil {
+			p.active.wait = make(chan struct{})
+		}
+		return nil, p.active.wait, nil
+	}
+	p.active.count[addr]++
+	p.inuse++
+	return nil, nil, nil
+}
+
+const (
+	EvictError    = "error"
+	EvictIdle     = "idle"
+	EvictLifetime = "lifetime"
+	EvictAuth     = "auth"
+	EvictDatabase = "database"
+	EvictPing     = "ping"
+	EvictMaxIdle  = "max-idle"
+	EvictNoReuse  = "no-reuse"
+	EvictRemoved  = "removed"
+	EvictClosed   = "closed"
+)
+
+// SetOnEvict registers fn to be called with the addr and one of the Evict*
+// reasons whenever the pool closes a client. It runs in its own goroutine,
+// never under the pool's lock, so it may call back into the pool.
+func (p *Pool) SetOnEvict(fn func(addr string, reason string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onEvict = fn
+}
+
+// evictReason returns why c can't be recycled, or "" if it can.
+func (p *Pool) evictReason(c *Client) string {
+	switch {
+	case p.maxLifetime != 0 && p.maxLifetime <= time.Since(c.CreatedAt):
+		return EvictLifetime
+	case c.User != p.option.User || c.Auth != p.authOf(c.Addr):
+		return EvictAuth
+	case c.conn.Err() != nil || c.Pipeline.Send != c.Pipeline.Recv:
+		return EvictError
+	case !c.isRecyclable():
+		return EvictIdle
+	}
+	return ""
+}
+
+// countEvicted counts a client closed for a reason given by evictReason,
+// only a broken connection is an error, the others have just expired.
+func (p *Pool) countEvicted(reason string) {
+	if reason == EvictError {
+		p.stats.closedOnError.Incr()
+	} else {
+		p.stats.closedOnExpire.Incr()
+	}
+}
+
+func (p *Pool) closeClient(c *Client, reason string) {
+	c.Close()
+	p.release(c.Addr)
+	p.stats.closed.Incr()
+	if fn := p.onEvict; fn != nil {
+		go fn(c.Addr, reason)
+	}
+}
+
+func (p *Pool) release(addr string) {
+	if n := p.active.count[addr] - 1; n > 0 {
+		p.active.count[addr] = n
+	} else {
+		delete(p.active.count, addr)
+	}
+	p.notify()
+}
+
+func (p *Pool) notify() {
+	if p.active.wait != nil {
+		close(p.active.wait)
+		p.active.wait = nil
+	}
 }
 
 func (p *Pool) PutClient(c *Client) {
+	p.mu.Lock()
+	database := p.option.Database
+	p.mu.Unlock()
+
+	if c.Database != database && c.isRecyclable() {
+		c.Select(database)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if !c.isRecyclable() || p.closed {
-		c.Close()
+	p.inuse = math2.MaxInt(p.inuse-1, 0)
+	if reason := p.evictReason(c); reason != "" || p.closed {
+		if p.closed {
+			reason = EvictClosed
+		}
+		p.closeClient(c, reason)
+		if !p.closed {
+			p.countEvicted(reason)
+		}
+	} else if p.noReuse.IsTrue() {
+		p.closeClient(c, EvictNoReuse)
 	} else {
 		cache := p.pool[c.Addr]
 		if cache == nil {
 			cache = list.New()
 			p.pool[c.Addr] = cache
 		}
-		cache.PushFront(c)
+		if p.maxIdle != 0 && cache.Len() >= p.maxIdle {
+			p.closeClient(c, EvictMaxIdle)
+		} else {
+			cache.PushFront(c)
+			p.notify()
+		}
+	}
+}
+
+type PoolAddrStats struct {
+	Idle   int `json:"idle"`
+	Active int `json:"active"`
+
+	Breaker string `json:"breaker,omitempty"`
+
+	Dial *DialLatencyStats `json:"dial,omitempty"`
+}
+
+// DialLatencyStats summarizes the latency of the recent successful dials to
+// an addr, in microseconds.
+type DialLatencyStats struct {
+	Samples int   `json:"samples"`
+	P50     int64 `json:"p50_usecs"`
+	P95     int64 `json:"p95_usecs"`
+	Max     int64 `json:"max_usecs"`
+}
+
+type PoolStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Dials  int64 `json:"dials"`
+
+	Created      int64 `json:"created"`
+	DialFailures int64 `json:"dial_failures"`
+
+	Closed          int64 `json:"closed"`
+	ClosedOnError   int64 `json:"closed_on_error"`
+	ClosedOnExpire  int64 `json:"closed_on_expire"`
+	ClosedOnCleanup int64 `json:"closed_on_cleanup"`
+
+	Idle   int `json:"idle"`
+	Active int `json:"active"`
+	InUse  int `json:"inuse"`
+
+	PerAddr map[string]*PoolAddrStats `json:"per_addr,omitempty"`
+}
+
+func (p *Pool) Stats() *PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := &PoolStats{
+		Hits:   p.stats.hits.Int64(),
+		Misses: p.stats.misses.Int64(),
+		Dials:  p.stats.dials.Int64(),
+
+		Created:      p.stats.created.Int64(),
+		DialFailures: p.stats.dialFailures.Int64(),
+
+		Closed:          p.stats.closed.Int64(),
+		ClosedOnError:   p.stats.closedOnError.Int64(),
+		ClosedOnExpire:  p.stats.closedOnExpire.Int64(),
+		ClosedOnCleanup: p.stats.closedOnCleanup.Int64(),
+
+		InUse: p.inuse,
+
+		PerAddr: make(map[string]*PoolAddrStats),
+	}
+	var addrStats = func(addr string) *PoolAddrStats {
+		if x := stats.PerAddr[addr]; x != nil {
+			return x
+		}
+		x := &PoolAddrStats{}
+		stats.PerAddr[addr] = x
+		return x
+	}
+	for addr, list := range p.pool {
+		stats.Idle += list.Len()
+		addrStats(addr).Idle = list.Len()
+	}
+	for addr, n := range p.active.count {
+		stats.Active += n
+		addrStats(addr).Active = n
+	}
+	for addr, b := range p.breaker.data {
+		if state := b.state(); state != "" {
+			addrStats(addr).Breaker = state
+		}
+	}
+	for addr, l := range p.latency.data {
+		addrStats(addr).Dial = l.stats()
+	}
+	return stats
+}
+
+// WithClient gets a client of addr, runs fn with it and puts it back, even
+// if fn fails or panics, so that a broken client is never leaked or reused.
+func (p *Pool) WithClient(addr string, fn func(c *Client) error) error {
+	c, err := p.GetClient(addr)
+	if err != nil {
+		return err
 	}
+	defer p.PutClient(c)
+	return fn(c)
 }
 
 func (p *Pool) Info(addr string) (_ map[string]string, err error) {
@@ -439,6 +2430,24 @@ func (p *Pool) Info(addr string) (_ map[string]string, err error) {
 	return c.Info()
 }
 
+func (p *Pool) infoContext(ctx context.Context, addr string) (map[string]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	default:
+	}
+	c, err := p.getClientContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer p.PutClient(c)
+	text, err := redigo.String(c.DoContext(ctx, "INFO"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parseInfo(text), nil
+}
+
 func (p *Pool) InfoFull(addr string) (_ map[string]string, err error) {
 	c, err := p.GetClient(addr)
 	if err != nil {
@@ -448,9 +2457,232 @@ func (p *Pool) InfoFull(addr string) (_ map[string]string, err error) {
 	return c.InfoFull()
 }
 
+// InfoMany runs INFO on addrs with at most parallel clients in flight,
+// parallel <= 0 means no limit. Failed addrs are reported in the error map
+// and don't abort the others.
+func (p *Pool) InfoMany(addrs []string, parallel int) (map[string]map[string]string, map[string]error) {
+	return p.InfoManyContext(context.Background(), addrs, parallel)
+}
+
+// InfoManyContext is like InfoMany, once ctx is done the pending addrs and
+// the requests in flight fail with the ctx error.
+func (p *Pool) InfoManyContext(ctx context.Context, addrs []string, parallel int) (map[string]map[string]string, map[string]error) {
+	if parallel <= 0 || parallel > len(addrs) {
+		parallel = len(addrs)
+	}
+	var (
+		mu    sync.Mutex
+		infos = make(map[string]map[string]string)
+		errs  = make(map[string]error)
+	)
+	var wg sync.WaitGroup
+	var ch = make(chan string)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range ch {
+				info, err := p.infoContext(ctx, addr)
+				mu.Lock()
+				if err != nil {
+					errs[addr] = err
+				} else {
+					infos[addr] = info
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, addr := range addrs {
+		ch <- addr
+	}
+	close(ch)
+	wg.Wait()
+	return infos, errs
+}
+
+// GroupHealth pings each of addrs concurrently, a nil error means healthy.
+// Cached clients are reused and broken ones are never put back.
+func (p *Pool) GroupHealth(addrs []string) map[string]error {
+	var (
+		mu     sync.Mutex
+		health = make(map[string]error)
+	)
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			err := p.ping(addr)
+			mu.Lock()
+			health[addr] = err
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return health
+}
+
+// SetGroupTopology makes master a master (SLAVEOF NO ONE) and then points
+// every one of slaves to it concurrently. Failures don't abort the others,
+// the returned map has an entry for each host and a nil error means success.
+func (p *Pool) SetGroupTopology(master string, slaves []string) map[string]error {
+	var result = make(map[string]error)
+	result[master] = p.setMaster(master, "NO:ONE")
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range slaves {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			err := p.setMaster(addr, master)
+			mu.Lock()
+			result[addr] = err
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return result
+}
+
+func (p *Pool) setMaster(addr string, master string) error {
+	return p.WithClient(addr, func(c *Client) error {
+		return c.SetMaster(master)
+	})
+}
+
+func (p *Pool) ping(addr string) error {
+	return p.WithClient(addr, func(c *Client) error {
+		if _, err := c.DoTimeout(time.Second, "PING"); err != nil {
+			return errors.Trace(err)
+		}
+		return nil
+	})
+}
+
+// Warmup dials up to count clients for each of addrs and puts them into the
+// pool. Addrs that fail are reported in the returned map, the others are
+// warmed up anyway. With SetMaxActive, count is capped by the clients still
+// available for each addr, so Warmup never waits for a free slot.
+func (p *Pool) Warmup(addrs []string, count int) map[string]error {
+	var (
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var clients []*Client
+			defer func() {
+				for _, c := range clients {
+					p.PutClient(c)
+				}
+			}()
+			for i, n := 0, p.warmupCount(addr, count); i < n; i++ {
+				c, err := p.GetClient(addr)
+				if err != nil {
+					mu.Lock()
+					errs[addr] = err
+					mu.Unlock()
+					return
+				}
+				clients = append(clients, c)
+			}
+		}(addr)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (p *Pool) warmupCount(addr string, count int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active.limit == 0 {
+		return count
+	}
+	var idle int
+	if list := p.pool[addr]; list != nil {
+		idle = list.Len()
+	}
+	return math2.MinInt(count, p.active.limit-p.active.count[addr]+idle)
+}
+
+const DefaultRoleTTL = time.Second * 10
+
+type poolRole struct {
+	role   string
+	expire time.Time
+}
+
+func (p *Pool) SetRoleTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roles.ttl = ttl
+	p.roles.data = make(map[string]*poolRole)
+}
+
+// Role returns the role of addr, the result is cached for the role ttl.
+func (p *Pool) Role(addr string) (string, error) {
+	p.mu.Lock()
+	if r := p.roles.data[addr]; r != nil && time.Now().Before(r.expire) {
+		p.mu.Unlock()
+		return r.role, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.GetClient(addr)
+	if err != nil {
+		return "", err
+	}
+	defer p.PutClient(c)
+	role, err := c.Role()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roles.data[addr] = &poolRole{
+		role: role, expire: time.Now().Add(p.roles.ttl),
+	}
+	return role, nil
+}
+
+// GetReplicaClient returns a client of one of the slaves in group, picked
+// round-robin, for read-only commands. It falls back to the master, or to
+// the first reachable server if no role is known.
+func (p *Pool) GetReplicaClient(group []string) (*Client, error) {
+	var slaves, others []string
+	for _, addr := range group {
+		role, err := p.Role(addr)
+		switch {
+		case err != nil:
+			continue
+		case role == "SLAVE":
+			slaves = append(slaves, addr)
+		case role == "MASTER":
+			others = append([]string{addr}, others...)
+		default:
+			others = append(others, addr)
+		}
+	}
+	if len(slaves) != 0 {
+		i := int(p.roles.next.Incr() % int64(len(slaves)))
+		return p.GetClient(slaves[i])
+	}
+	if len(others) != 0 {
+		return p.GetClient(others[0])
+	}
+	return nil, errors.Errorf("no available server in group %v", group)
+}
+
 type InfoCache struct {
 	mu sync.Mutex
 
+	User string
 	Auth string
 	data map[string]map[string]string
 
@@ -494,7 +2726,7 @@ func (s *InfoCache) GetRunId(addr string) string {
 }
 
 func (s *InfoCache) getSlow(addr string) (map[string]string, error) {
-	c, err := NewClient(addr, s.Auth, s.Timeout)
+	c, err := NewClientWithUser(addr, s.User, s.Auth, s.Timeout)
 	if err != nil {
 		return nil, err
 	}
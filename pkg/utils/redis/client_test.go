@@ -0,0 +1,2693 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/sync2/atomic2"
+
+	resp "github.com/CodisLabs/codis/pkg/proxy/redis"
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+type fakeServer struct {
+	net.Listener
+	Handler func(args []string) *resp.Resp
+}
+
+func newFakeServer(handler func(args []string) *resp.Resp) *fakeServer {
+	return newFakeServerOn("tcp", "127.0.0.1:0", handler)
+}
+
+func newFakeServerOn(network, addr string, handler func(args []string) *resp.Resp) *fakeServer {
+	l, err := net.Listen(network, addr)
+	assert.MustNoError(err)
+	return newFakeServerListener(l, handler)
+}
+
+func newFakeServerListener(l net.Listener, handler func(args []string) *resp.Resp) *fakeServer {
+	s := &fakeServer{Listener: l, Handler: handler}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(resp.NewConn(c, 1024, 1024))
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+func (s *fakeServer) serve(c *resp.Conn) {
+	defer c.Close()
+	for {
+		multi, err := c.DecodeMultiBulk()
+		if err != nil {
+			return
+		}
+		var args = make([]string, len(multi))
+		for i := range multi {
+			args[i] = string(multi[i].Value)
+		}
+		args[0] = strings.ToUpper(args[0])
+		r := s.Handler(args)
+		if r == nil {
+			return
+		}
+		if err := c.Encode(r, true); err != nil {
+			return
+		}
+	}
+}
+
+func newOKServer() *fakeServer {
+	return newFakeServer(func(args []string) *resp.Resp {
+		return resp.NewString([]byte("OK"))
+	})
+}
+
+func TestPoolMaxIdle(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	const n = 4
+	p := NewPool("", time.Second)
+	defer p.Close()
+	p.SetMaxIdle(n)
+
+	var clients []*Client
+	for i := 0; i < n+5; i++ {
+		c, err := p.GetClient(s.Addr())
+		assert.MustNoError(err)
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		p.PutClient(c)
+	}
+
+	var closed int
+	for _, c := range clients {
+		if c.conn.Err() != nil {
+			closed++
+		}
+	}
+	assert.Must(closed == 5)
+	assert.Must(p.pool[s.Addr()].Len() == n)
+}
+
+func TestClientDoContext(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Second)
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Minute)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.SlotsInfoContext(ctx)
+	assert.Must(err != nil)
+	assert.Must(time.Since(start) < time.Millisecond*500)
+	assert.Must(!c.isRecyclable())
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err = c.DoContext(ctx, "PING")
+	assert.Must(errors.Equal(err, context.DeadlineExceeded))
+	p.PutClient(c)
+	assert.Must(p.Stats().Idle == 0)
+}
+
+func TestClientMigrateTimeout(t *testing.T) {
+	var timeout = make(chan string, 2)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		timeout <- args[3]
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("0")), resp.NewInt([]byte("0")),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second*5)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.MigrateSlot(0, "127.0.0.1:6379")
+	assert.MustNoError(err)
+	assert.Must(<-timeout == "5000")
+
+	c.MigrateTimeout = time.Second * 30
+	_, err = c.MigrateSlot(0, "127.0.0.1:6379")
+	assert.MustNoError(err)
+	assert.Must(<-timeout == "30000")
+
+	p := NewPool("", time.Second*5)
+	defer p.Close()
+
+	assert.Must(p.SetMigrateTimeout(0) != nil)
+	assert.Must(p.SetMigrateTimeout(-time.Second) != nil)
+	assert.MustNoError(p.SetMigrateTimeout(time.Second * 10))
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	defer p.PutClient(c)
+
+	_, err = c.MigrateSlot(0, "127.0.0.1:6379")
+	assert.MustNoError(err)
+	assert.Must(<-timeout == "10000")
+}
+
+func TestClientMigrateSlotAsyncReadTimeout(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Millisecond * 300)
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("1")), resp.NewInt([]byte("0")),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Millisecond*100)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	c.MigrateTimeout = time.Second
+	remain, err := c.MigrateSlotWithLimit(0, "127.0.0.1:6379", 10)
+	assert.MustNoError(err)
+	assert.Must(remain == 0)
+}
+
+func TestPoolDoContextNotRecycled(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Second)
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+	_, err = c.MigrateSlotAsyncContext(ctx, 0, "127.0.0.1:6379", &MigrateSlotAsyncOption{})
+	assert.Must(err != nil)
+
+	p.PutClient(c)
+	assert.Must(p.pool[s.Addr()] == nil)
+}
+
+func TestClientPing(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "PING":
+			return resp.NewString([]byte("PONG"))
+		}
+		return nil
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	assert.MustNoError(c.Ping())
+	assert.Must(c.isRecyclable())
+
+	_, err = c.Do("QUIT")
+	assert.Must(err != nil)
+	assert.Must(c.Ping() != nil)
+	assert.Must(!c.isRecyclable())
+}
+
+func TestPoolDialRetry(t *testing.T) {
+	var accepts atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "AUTH" && accepts.Incr() < 3 {
+			return nil
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("foobar", time.Second)
+	defer p.Close()
+
+	p.SetDialRetry(3, time.Millisecond*10)
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(accepts.Int64() == 3)
+	p.PutClient(c)
+}
+
+func TestClientOptionDialTimeout(t *testing.T) {
+	var o = &ClientOption{Timeout: time.Second * 5}
+	assert.Must(o.dialTimeout() == time.Second)
+	o.Timeout = time.Millisecond * 100
+	assert.Must(o.dialTimeout() == time.Millisecond*100)
+	o.DialTimeout = time.Second * 3
+	assert.Must(o.dialTimeout() == time.Second*3)
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	p.SetDialTimeout(time.Second * 3)
+	assert.Must(p.option.dialTimeout() == time.Second*3)
+}
+
+func newBulkArray(values ...string) *resp.Resp {
+	var array []*resp.Resp
+	for _, v := range values {
+		array = append(array, resp.NewBulkBytes([]byte(v)))
+	}
+	return resp.NewArray(array)
+}
+
+func TestClientMigrateSlotAsyncStatus(t *testing.T) {
+	var running atomic2.Bool
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if !running.IsTrue() {
+			return resp.NewArray(nil)
+		}
+		iter := newBulkArray("estimate_msgs", "7", "removed_keys", "3", "chunked_vals", "0")
+		iter.Array = append(iter.Array,
+			resp.NewBulkBytes([]byte("keys")), resp.NewArray([]*resp.Resp{
+				resp.NewBulkBytes([]byte("2")), newBulkArray("key1", "key2"),
+			}))
+		status := newBulkArray("host", "127.0.0.1", "port", "6380", "timeout", "30000",
+			"since_lastuse", "10", "sending_msgs", "4", "blocked_clients", "1")
+		status.Array = append(status.Array,
+			resp.NewBulkBytes([]byte("batched_iterator")), iter)
+		return status
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	status, err := c.MigrateSlotAsyncStatus()
+	assert.MustNoError(err)
+	assert.Must(status == nil)
+
+	running.Set(true)
+	status, err = c.MigrateSlotAsyncStatus()
+	assert.MustNoError(err)
+	assert.Must(status.Host == "127.0.0.1" && status.Port == 6380)
+	assert.Must(status.Timeout == time.Second*30)
+	assert.Must(status.SendingMsgs == 4 && status.BlockedClients == 1)
+	assert.Must(status.BatchKeys == 2 && status.EstimateMsgs == 7)
+	assert.Must(status.RemovedKeys == 3)
+}
+
+func TestClientMigrateTagOne(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] != "SLOTSMGRTTAGONE" || args[4] != "{tag}key" {
+			return resp.NewErrorf("ERR unknown command")
+		}
+		return resp.NewInt([]byte("3"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.MigrateTagOne("{tag}key", "127.0.0.1:6380")
+	assert.MustNoError(err)
+	assert.Must(n == 3)
+
+	_, err = c.MigrateTagOne("{tag}key", "127.0.0.1")
+	assert.Must(err != nil)
+}
+
+func TestClientMigrateSlotNoTag(t *testing.T) {
+	var command = make(chan []string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- args
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("1")), resp.NewInt([]byte("9")),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.MigrateSlotNoTag(7, "127.0.0.1:6380")
+	assert.MustNoError(err)
+	assert.Must(n == 9)
+	assert.Must(strings.Join(<-command, " ") == "SLOTSMGRTSLOT 127.0.0.1 6380 1000 7")
+}
+
+func TestClientSlotsScan(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[2] {
+		case "0":
+			return resp.NewArray([]*resp.Resp{
+				resp.NewBulkBytes([]byte("7")), newBulkArray("key1", "key2"),
+			})
+		default:
+			return resp.NewArray([]*resp.Resp{
+				resp.NewBulkBytes([]byte("0")), newBulkArray("key3"),
+			})
+		}
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	var keys []string
+	for cursor := 0; ; {
+		next, p, err := c.SlotsScan(100, cursor, 2)
+		assert.MustNoError(err)
+		for _, key := range p {
+			keys = append(keys, string(key))
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	assert.Must(strings.Join(keys, ",") == "key1,key2,key3")
+}
+
+func TestClientSlotsDel(t *testing.T) {
+	var command = make(chan []string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "SLOTSINFO" {
+			return resp.NewArray([]*resp.Resp{
+				resp.NewArray([]*resp.Resp{resp.NewInt([]byte(args[1])), resp.NewInt([]byte("5"))}),
+			})
+		}
+		command <- args
+		var array []*resp.Resp
+		for _, slot := range args[1:] {
+			var remains = "0"
+			if slot == "2" {
+				remains = "1"
+			}
+			array = append(array, resp.NewArray([]*resp.Resp{
+				resp.NewInt([]byte(slot)), resp.NewInt([]byte(remains)),
+			}))
+		}
+		return resp.NewArray(array)
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	removed, err := c.SlotsDel(1, 2, 1023)
+	assert.MustNoError(err)
+	assert.Must(strings.Join(<-command, " ") == "SLOTSDEL 1 2 1023")
+	assert.Must(len(removed) == 3 && removed[1023] == 5 && removed[2] == 4)
+
+	_, err = c.SlotsDel(1, MaxSlotNum)
+	assert.Must(err != nil)
+	assert.Must(len(command) == 0)
+}
+
+func TestClientKeyCount(t *testing.T) {
+	var command = make(chan []string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- args
+		switch args[0] {
+		case "DBSIZE":
+			return resp.NewInt([]byte("42"))
+		}
+		var array = []*resp.Resp{}
+		for _, p := range [][2]string{{"1", "10"}, {"7", "32"}} {
+			if len(args) == 3 && p[0] != args[1] {
+				continue
+			}
+			array = append(array, resp.NewArray([]*resp.Resp{
+				resp.NewInt([]byte(p[0])), resp.NewInt([]byte(p[1])),
+			}))
+		}
+		return resp.NewArray(array)
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.DBSize()
+	assert.MustNoError(err)
+	assert.Must(n == 42 && len(<-command) == 1)
+
+	n, err = c.TotalKeys()
+	assert.MustNoError(err)
+	assert.Must(n == 42 && len(<-command) == 1)
+
+	n, err = c.SlotsKeyCount(7)
+	assert.MustNoError(err)
+	assert.Must(n == 32)
+	assert.Must(strings.Join(<-command, " ") == "SLOTSINFO 7 1")
+
+	n, err = c.SlotsKeyCount(2)
+	assert.MustNoError(err)
+	assert.Must(n == 0)
+	assert.Must(strings.Join(<-command, " ") == "SLOTSINFO 2 1")
+
+	empty, err := c.SlotsKeysEmpty(7)
+	assert.MustNoError(err)
+	assert.Must(!empty && len(<-command) == 3)
+
+	empty, err = c.SlotsKeysEmpty(2)
+	assert.MustNoError(err)
+	assert.Must(empty && len(<-command) == 3)
+
+	_, err = c.SlotsKeyCount(MaxSlotNum)
+	assert.Must(err != nil)
+	assert.Must(len(command) == 0)
+}
+
+func newRemainsServer(remains ...int) *fakeServer {
+	var calls atomic2.Int64
+	return newFakeServer(func(args []string) *resp.Resp {
+		i := int(calls.Incr()) - 1
+		if i >= len(remains) {
+			i = len(remains) - 1
+		}
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("1")), resp.NewInt([]byte(strconv.Itoa(remains[i]))),
+		})
+	})
+}
+
+func TestClientDrainSlot(t *testing.T) {
+	s1 := newRemainsServer(3, 2, 1, 0)
+	defer s1.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	var progress []int
+	assert.MustNoError(c1.DrainSlot(0, "127.0.0.1:6380", 3, func(remaining int) {
+		progress = append(progress, remaining)
+	}))
+	assert.Must(len(progress) == 4 && progress[3] == 0)
+
+	s2 := newRemainsServer(3, 2, 2)
+	defer s2.Close()
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	err = c2.DrainSlot(0, "127.0.0.1:6380", 3, nil)
+	assert.Must(errors.Equal(err, ErrMigrateStalled))
+}
+
+func TestClientMigrateSlotRounds(t *testing.T) {
+	var command = make(chan string, 16)
+	s1 := newFakeServer(func(args []string) *resp.Resp {
+		command <- args[0]
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("2")), resp.NewInt([]byte(strconv.Itoa(3 - len(command)))),
+		})
+	})
+	defer s1.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	moved, rounds, err := c1.MigrateSlotRounds(0, "127.0.0.1:6380", 0, 16)
+	assert.MustNoError(err)
+	assert.Must(moved == 6 && rounds == 3)
+	assert.Must(<-command == "SLOTSMGRTTAGSLOT-ASYNC")
+
+	s2 := newRemainsServer(3, 2, 1, 0)
+	defer s2.Close()
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	moved, rounds, err = c2.MigrateSlotRounds(0, "127.0.0.1:6380", 2, 0)
+	assert.Must(err != nil)
+	assert.Must(moved == 2 && rounds == 2)
+}
+
+func TestClientSlotsRestore(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	assert.MustNoError(c.SlotsRestore(
+		&SlotEntry{Key: []byte("a"), Value: []byte("x")},
+		&SlotEntry{Key: []byte("b"), TTL: time.Second, Value: []byte("y")},
+	))
+	assert.Must(<-command == "SLOTSRESTORE a 0 x b 1000 y")
+}
+
+func TestClientScan(t *testing.T) {
+	var command = make(chan string, 2)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewArray([]*resp.Resp{
+			resp.NewBulkBytes([]byte("0")),
+			resp.NewArray([]*resp.Resp{resp.NewBulkBytes([]byte("user:\x00"))}),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	next, keys, err := c.Scan(0, "user:*", 100)
+	assert.MustNoError(err)
+	assert.Must(next == 0 && len(keys) == 1 && string(keys[0]) == "user:\x00")
+	assert.Must(<-command == "SCAN 0 MATCH user:* COUNT 100")
+
+	_, _, err = c.Scan(7, "", 0)
+	assert.MustNoError(err)
+	assert.Must(<-command == "SCAN 7")
+}
+
+func TestClientObject(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch {
+		case args[2] == "a" && args[1] == "ENCODING":
+			return resp.NewBulkBytes([]byte("ziplist"))
+		case args[2] == "a" && args[1] == "IDLETIME":
+			return resp.NewInt([]byte("42"))
+		case args[2] == "b":
+			return resp.NewBulkBytes(nil)
+		}
+		return resp.NewError([]byte("ERR no such key"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	encoding, err := c.ObjectEncoding("a")
+	assert.MustNoError(err)
+	assert.Must(encoding == "ziplist")
+
+	idle, err := c.ObjectIdleTime("a")
+	assert.MustNoError(err)
+	assert.Must(idle == 42)
+
+	_, err = c.ObjectEncoding("b")
+	assert.Must(errors.Equal(err, ErrNoSuchKey))
+	_, err = c.ObjectIdleTime("c")
+	assert.Must(errors.Equal(err, ErrNoSuchKey))
+}
+
+func TestClientSlotsSample(t *testing.T) {
+	var counts = make(chan string, 16)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		counts <- args[len(args)-1]
+		cursor, _ := strconv.Atoi(args[2])
+		var keys []*resp.Resp
+		for i := 0; i < 3; i++ {
+			keys = append(keys, resp.NewBulkBytes([]byte(fmt.Sprintf("key%d", cursor*3+i))))
+		}
+		next := cursor + 1
+		if next == 4 {
+			next = 0
+		}
+		return resp.NewArray([]*resp.Resp{
+			resp.NewBulkBytes([]byte(strconv.Itoa(next))), resp.NewArray(keys),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	keys, err := c.SlotsSample(1, 5)
+	assert.MustNoError(err)
+	assert.Must(len(keys) == 5 && keys[4] == "key4")
+	assert.Must(<-counts == "5" && <-counts == "2")
+
+	keys, err = c.SlotsSample(1, 1000)
+	assert.MustNoError(err)
+	assert.Must(len(keys) == 12)
+	assert.Must(<-counts == "100")
+}
+
+func TestClientSlotMemoryEstimate(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "SLOTSINFO":
+			return resp.NewArray([]*resp.Resp{
+				resp.NewArray([]*resp.Resp{resp.NewInt([]byte(args[1])), resp.NewInt([]byte("10"))}),
+			})
+		case "SLOTSSCAN":
+			return resp.NewArray([]*resp.Resp{
+				resp.NewBulkBytes([]byte("0")),
+				resp.NewArray([]*resp.Resp{
+					resp.NewBulkBytes([]byte("a")), resp.NewBulkBytes([]byte("b")), resp.NewBulkBytes([]byte("c")),
+				}),
+			})
+		case "MEMORY":
+			switch args[2] {
+			case "a":
+				return resp.NewInt([]byte("100"))
+			case "b":
+				return resp.NewInt([]byte("300"))
+			}
+			return resp.NewBulkBytes(nil)
+		}
+		return resp.NewError([]byte("ERR unknown command"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.SlotMemoryEstimate(1, 3)
+	assert.MustNoError(err)
+	assert.Must(n == 2000)
+
+	_, err = c.KeyMemoryUsage("c")
+	assert.Must(errors.Equal(err, ErrNoSuchKey))
+
+	keys, err := c.SlotBiggestKeys(1, 3, 1)
+	assert.MustNoError(err)
+	assert.Must(len(keys) == 1 && keys[0].Key == "b" && keys[0].Bytes == 300)
+}
+
+func TestClientKeyMemoryUsageDebugObject(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "DEBUG":
+			switch args[2] {
+			case "a":
+				return resp.NewString([]byte("Value at:0x7f2b1c00e0c0 refcount:1 encoding:raw serializedlength:42 lru:1 lru_seconds_idle:3"))
+			case "b":
+				return resp.NewString([]byte("Value at:0x7f2b1c00e0c0 refcount:1 encoding:raw"))
+			}
+			return resp.NewErrorf("ERR no such key")
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.KeyMemoryUsage("a")
+	assert.MustNoError(err)
+	assert.Must(n == 42)
+
+	_, err = c.KeyMemoryUsage("b")
+	assert.Must(err != nil)
+
+	_, err = c.KeyMemoryUsage("c")
+	assert.Must(errors.Equal(err, ErrNoSuchKey))
+}
+
+func TestClientSocketAddr(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	assert.Must(c.RemoteAddr().String() == s.Addr())
+	local, ok := c.LocalAddr().(*net.TCPAddr)
+	assert.Must(ok && local.Port != 0 && local.String() != s.Addr())
+}
+
+func TestClientTransaction(t *testing.T) {
+	var queued []string
+	var command = make(chan string, 16)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- args[0]
+		switch args[0] {
+		case "MULTI", "DISCARD":
+			queued = nil
+			return resp.NewString([]byte("OK"))
+		case "EXEC":
+			var array = []*resp.Resp{}
+			for _, key := range queued {
+				array = append(array, resp.NewBulkBytes([]byte(key)))
+			}
+			return resp.NewArray(array)
+		case "GET":
+			queued = append(queued, args[1])
+			return resp.NewString([]byte("QUEUED"))
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	values, err := c.Transaction(func(tx *Tx) error {
+		tx.Send("GET", "a")
+		return tx.Send("GET", "b")
+	})
+	assert.MustNoError(err)
+	assert.Must(len(values) == 2 && string(values[1].([]byte)) == "b")
+	for _, cmd := range []string{"MULTI", "GET", "GET", "EXEC"} {
+		assert.Must(<-command == cmd)
+	}
+
+	_, err = c.Transaction(func(tx *Tx) error {
+		tx.Send("GET", "a")
+		return errors.New("abort")
+	})
+	assert.Must(err != nil && err.Error() == "abort")
+	for _, cmd := range []string{"MULTI", "GET", "DISCARD"} {
+		assert.Must(<-command == cmd)
+	}
+	assert.Must(c.isRecyclable())
+
+	func() {
+		defer func() {
+			assert.Must(recover() != nil)
+		}()
+		c.Transaction(func(tx *Tx) error {
+			tx.Send("GET", "a")
+			panic("abort")
+		})
+	}()
+	assert.Must(!c.isRecyclable())
+}
+
+func TestClientRedirectError(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[1] {
+		case "a":
+			return resp.NewError([]byte("MOVED 3999 127.0.0.1:6381"))
+		case "b":
+			return resp.NewError([]byte("ASK 3999 127.0.0.1:6381"))
+		}
+		return resp.NewError([]byte("ERR MOVED somewhere"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.Do("GET", "a")
+	r, ok := errors.Cause(err).(*RedirectError)
+	assert.Must(ok && !r.Ask && r.Slot == 3999 && r.Addr == "127.0.0.1:6381")
+
+	_, err = c.Do("GET", "b")
+	r, ok = errors.Cause(err).(*RedirectError)
+	assert.Must(ok && r.Ask && r.Error() == "ASK 3999 127.0.0.1:6381")
+
+	_, err = c.Do("GET", "c")
+	_, ok = errors.Cause(err).(redigo.Error)
+	assert.Must(ok)
+	assert.Must(c.isRecyclable())
+}
+
+func TestClientConfigSet(t *testing.T) {
+	var config = make(map[string]string)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if len(args) != 4 || args[1] != "SET" {
+			return resp.NewErrorf("ERR wrong number of arguments")
+		}
+		switch args[2] {
+		case "maxmemory":
+			config[args[2]] = args[3]
+			return resp.NewString([]byte("OK"))
+		default:
+			return resp.NewBulkBytes([]byte("QUEUED"))
+		}
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	assert.MustNoError(c.SetMaxMemory(1 << 30))
+	assert.Must(config["maxmemory"] == "1073741824")
+
+	assert.Must(c.ConfigSet("appendonly", "yes") != nil)
+}
+
+func TestClientConfigGet(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[2] {
+		case "maxmemory-policy":
+			return newBulkArray(args[2], "noeviction")
+		case "*":
+			return newBulkArray("maxmemory-policy", "noeviction", "appendonly", "no")
+		default:
+			return newBulkArray()
+		}
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	policy, err := c.MaxMemoryPolicy()
+	assert.MustNoError(err)
+	assert.Must(policy == "noeviction")
+
+	_, err = c.ConfigGet("unknown")
+	assert.Must(err != nil)
+
+	config, err := c.ConfigGetAll()
+	assert.MustNoError(err)
+	assert.Must(len(config) == 2 && config["appendonly"] == "no")
+}
+
+func newInfoServer(lines ...string) *fakeServer {
+	return newFakeServer(func(args []string) *resp.Resp {
+		if args[0] != "INFO" {
+			return resp.NewErrorf("ERR unknown command '%s'", args[0])
+		}
+		return resp.NewBulkBytes([]byte(strings.Join(lines, "\r\n")))
+	})
+}
+
+func TestClientInfoSection(t *testing.T) {
+	var section = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		section <- strings.Join(args[1:], " ")
+		return resp.NewBulkBytes([]byte(strings.Join([]string{
+			"# Memory",
+			"used_memory:1024",
+			"maxmemory_policy:noeviction",
+			"",
+		}, "\r\n")))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	info, err := c.InfoSection("memory")
+	assert.MustNoError(err)
+	assert.Must(<-section == "memory")
+	assert.Must(len(info) == 2)
+	assert.Must(info["used_memory"] == "1024" && info["maxmemory_policy"] == "noeviction")
+}
+
+func TestClientReplLag(t *testing.T) {
+	s1 := newInfoServer("role:master", "master_repl_offset:5000")
+	defer s1.Close()
+	s2 := newInfoServer("role:slave", "master_link_status:up",
+		"slave_repl_offset:4200", "master_repl_offset:0")
+	defer s2.Close()
+
+	master, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer master.Close()
+
+	slave, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer slave.Close()
+
+	lag, err := slave.ReplLag(master)
+	assert.MustNoError(err)
+	assert.Must(lag == 800)
+}
+
+func TestClientCommandError(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "LPUSH":
+			return resp.NewErrorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		case "QUIT":
+			return nil
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.Do("LPUSH", "key", "value")
+	assert.Must(err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE"))
+	assert.Must(c.isRecyclable())
+
+	_, err = c.Do("QUIT")
+	assert.Must(err != nil)
+	assert.Must(!c.isRecyclable())
+}
+
+func TestPoolStats(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "QUIT" {
+			return nil
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c1)
+	p.PutClient(c2)
+
+	c3, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c3.Do("QUIT")
+	p.PutClient(c3)
+
+	_, err = p.GetClient("127.0.0.1:0")
+	assert.Must(err != nil)
+
+	stats := p.Stats()
+	assert.Must(stats.Hits == 1 && stats.Misses == 3 && stats.Dials == 3)
+	assert.Must(stats.Created == 2 && stats.DialFailures == 1)
+	assert.Must(stats.Closed == 1 && stats.ClosedOnError == 1 && stats.ClosedOnCleanup == 0)
+	assert.Must(stats.Idle == 1)
+	assert.Must(len(stats.PerAddr) == 1)
+	x := stats.PerAddr[s.Addr()]
+	assert.Must(x.Idle == 1 && x.Active == 1 && x.Breaker == "")
+	assert.Must(x.Dial != nil && x.Dial.Samples == 2)
+	assert.Must(x.Dial.P50 <= x.Dial.P95 && x.Dial.P95 <= x.Dial.Max)
+}
+
+func TestPoolAuthUser(t *testing.T) {
+	var auth = make(chan string, 2)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "AUTH" {
+			auth <- strings.Join(args[1:], " ")
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "foobar", time.Second)
+	assert.MustNoError(err)
+	c.Close()
+	assert.Must(<-auth == "foobar")
+
+	p := NewPoolWithUser("codis", "foobar", time.Second)
+	defer p.Close()
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c.Close()
+	assert.Must(<-auth == "codis foobar")
+	assert.Must(c.User == "codis")
+
+	p.SetAuth("admin", "secret")
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c.Close()
+	assert.Must(<-auth == "admin secret")
+}
+
+func TestPoolSetAuth(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("foobar", time.Second)
+	defer p.Close()
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c1)
+	assert.Must(p.Stats().Idle == 1)
+
+	p.SetAuth("", "secret")
+	assert.Must(p.Stats().Idle == 0)
+
+	p.PutClient(c2)
+	assert.Must(p.Stats().Idle == 0)
+
+	c3, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c3.Auth == "secret")
+	p.PutClient(c3)
+	assert.Must(p.Stats().Idle == 1)
+}
+
+func TestPoolSetDatabase(t *testing.T) {
+	var command = make(chan string, 16)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	assert.Must(len(command) == 0)
+
+	p.SetDatabase(2)
+	assert.Must(p.Stats().Idle == 0)
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(<-command == "SELECT 2" && c.Database == 2)
+
+	assert.MustNoError(c.Select(5))
+	assert.Must(<-command == "SELECT 5")
+	p.PutClient(c)
+	assert.Must(<-command == "SELECT 2" && c.Database == 2)
+
+	stats := p.Stats()
+	assert.Must(stats.Idle == 1 && stats.Closed == 1 && stats.ClosedOnError == 0)
+
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c2 == c && len(command) == 0)
+	p.PutClient(c2)
+}
+
+func TestPoolSetAddrAuth(t *testing.T) {
+	s1 := newOKServer()
+	defer s1.Close()
+	s2 := newOKServer()
+	defer s2.Close()
+
+	p := NewPool("foobar", time.Second)
+	defer p.Close()
+
+	c1, err := p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c1)
+	assert.Must(p.Stats().Idle == 1)
+
+	p.SetAddrAuth(s1.Addr(), "secret")
+	assert.Must(p.Stats().Idle == 0)
+
+	c1, err = p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	assert.Must(c1.Auth == "secret")
+	c2, err := p.GetClient(s2.Addr())
+	assert.MustNoError(err)
+	assert.Must(c2.Auth == "foobar")
+
+	p.ResetAddrAuth(s1.Addr())
+	p.PutClient(c1)
+	p.PutClient(c2)
+	assert.Must(p.Stats().Idle == 1)
+
+	c1, err = p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	assert.Must(c1.Auth == "foobar")
+	p.PutClient(c1)
+}
+
+func TestClientMemoryUsage(t *testing.T) {
+	s1 := newInfoServer("# Memory", "used_memory:1048576", "maxmemory:4194304")
+	defer s1.Close()
+	s2 := newInfoServer("# Memory", "used_memory:1048576")
+	defer s2.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	used, max, ratio, err := c1.MemoryUsage()
+	assert.MustNoError(err)
+	assert.Must(used == 1048576 && max == 4194304 && ratio == 0.25)
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	_, _, _, err = c2.MemoryUsage()
+	assert.Must(err != nil)
+}
+
+func TestClientSlaves(t *testing.T) {
+	s1 := newInfoServer("role:master", "connected_slaves:0")
+	defer s1.Close()
+	s2 := newInfoServer("role:master", "connected_slaves:2",
+		"slave0:ip=10.0.0.2,port=6380,state=online,offset=1200,lag=0",
+		"slave1:ip=10.0.0.3,port=6380,state=send_bulk,offset=0,lag=0")
+	defer s2.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	slaves, err := c1.Slaves()
+	assert.MustNoError(err)
+	assert.Must(slaves != nil && len(slaves) == 0)
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	slaves, err = c2.Slaves()
+	assert.MustNoError(err)
+	assert.Must(len(slaves) == 1 && slaves[0] == "10.0.0.2:6380")
+}
+
+func TestPoolMaxIdleConcurrent(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	const n = 8
+	p := NewPool("", time.Second)
+	defer p.Close()
+	p.SetMaxIdle(n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				c, err := p.GetClient(s.Addr())
+				assert.MustNoError(err)
+				_, err = c.Do("SET", "key", "value")
+				assert.MustNoError(err)
+				p.PutClient(c)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Must(p.Stats().Idle == n)
+}
+
+func TestPoolMaxActive(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+	p.SetMaxActive(2, time.Millisecond*100)
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	_, err = p.GetClient(s.Addr())
+	assert.Must(err == ErrPoolExhausted)
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		p.PutClient(c1)
+	}()
+	c3, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c3 == c1)
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		c2.Close()
+		p.PutClient(c2)
+	}()
+	c4, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c4 != c2)
+
+	p.PutClient(c3)
+	p.PutClient(c4)
+	assert.Must(p.Stats().Active == 2 && p.Stats().Idle == 2)
+}
+
+func TestPoolValidateOnBorrow(t *testing.T) {
+	var pings atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "PING" && pings.Incr() == 1 {
+			return nil
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+	p.SetValidateOnBorrow(true)
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c1)
+
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c1 != c2 && !c1.isRecyclable())
+	p.PutClient(c2)
+
+	c3, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c2 == c3)
+	p.PutClient(c3)
+
+	assert.Must(pings.Int64() == 2)
+}
+
+func TestClientDoTimeout(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "DEBUG" {
+			time.Sleep(time.Millisecond * 200)
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Millisecond*100)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	_, err = c.DoTimeout(time.Second, "DEBUG", "SLEEP", "0.2")
+	assert.MustNoError(err)
+
+	time.Sleep(time.Millisecond * 150)
+	_, err = c.Do("PING")
+	assert.MustNoError(err)
+
+	_, err = c.Do("DEBUG", "SLEEP", "0.2")
+	assert.Must(err != nil && !c.isRecyclable())
+}
+
+func TestPoolStartCleanup(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	c.Close()
+
+	p.StartCleanup(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 100)
+	p.StopCleanup()
+
+	stats := p.Stats()
+	assert.Must(stats.ClosedOnCleanup == 1 && stats.Idle == 0)
+
+	p.StartCleanup(time.Millisecond)
+	p.Close()
+	p.StartCleanup(time.Millisecond)
+}
+
+func TestPoolOnEvict(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	var evicted = make(chan string, 16)
+	p.SetOnEvict(func(addr string, reason string) {
+		assert.Must(addr == s.Addr())
+		assert.Must(p.Stats() != nil)
+		evicted <- reason
+	})
+	p.SetMaxIdle(1)
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c3, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	p.PutClient(c1)
+	p.PutClient(c2)
+	assert.Must(<-evicted == EvictMaxIdle)
+
+	c3.Close()
+	p.PutClient(c3)
+	assert.Must(<-evicted == EvictError)
+
+	assert.MustNoError(p.Remove(s.Addr()))
+	assert.Must(<-evicted == EvictRemoved)
+}
+
+func TestPoolPingOnCleanup(t *testing.T) {
+	var pings atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] == "PING" && pings.Incr() == 1 {
+			time.Sleep(time.Millisecond * 200)
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c1)
+	p.PutClient(c2)
+
+	assert.MustNoError(p.Cleanup())
+	assert.Must(pings.Int64() == 0 && p.Stats().Idle == 2)
+
+	p.SetPingOnCleanup(time.Millisecond * 50)
+	assert.MustNoError(p.Cleanup())
+
+	stats := p.Stats()
+	assert.Must(pings.Int64() == 2)
+	assert.Must(stats.Idle == 1 && stats.ClosedOnCleanup == 1)
+}
+
+func TestPoolMaxLifetime(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetMaxLifetime(time.Hour)
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	c1.CreatedAt = c1.CreatedAt.Add(-time.Hour)
+	p.PutClient(c1)
+	p.PutClient(c2)
+
+	stats := p.Stats()
+	assert.Must(stats.Closed == 1 && stats.Idle == 1)
+	assert.Must(stats.ClosedOnError == 0 && stats.ClosedOnExpire == 1)
+
+	c2.CreatedAt = c2.CreatedAt.Add(-time.Hour)
+	assert.MustNoError(p.Cleanup())
+
+	stats = p.Stats()
+	assert.Must(stats.Closed == 2 && stats.Idle == 0)
+
+	p.SetMaxLifetime(time.Millisecond * 50)
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	time.Sleep(time.Millisecond * 100)
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	assert.Must(p.Stats().Hits == 0 && p.Stats().Created == 4)
+	assert.Must(p.Stats().ClosedOnError == 0 && p.Stats().ClosedOnExpire == 2)
+
+	p.SetMaxLifetime(0)
+	assert.MustNoError(p.Remove(s.Addr()))
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c.CreatedAt = c.CreatedAt.Add(-time.Hour * 24)
+	p.PutClient(c)
+
+	stats = p.Stats()
+	assert.Must(stats.Closed == 4 && stats.Idle == 1)
+}
+
+func TestPoolDrain(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+
+	c1, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	c2, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(p.Stats().InUse == 2)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		p.PutClient(c1)
+		time.Sleep(time.Millisecond * 50)
+		p.PutClient(c2)
+	}()
+	assert.MustNoError(p.Drain(context.Background()))
+
+	stats := p.Stats()
+	assert.Must(stats.InUse == 0 && stats.Idle == 0 && stats.Active == 0)
+
+	_, err = p.GetClient(s.Addr())
+	assert.Must(errors.Equal(err, ErrClosedPool))
+
+	p = NewPool("", time.Minute)
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Must(errors.Equal(p.Drain(ctx), context.DeadlineExceeded))
+
+	p.PutClient(c)
+	assert.Must(c.conn.Err() != nil)
+	assert.Must(p.Stats().Active == 0)
+}
+
+func TestPoolDrainDialFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p := NewPool("", time.Minute)
+
+	_, err = p.GetClient(addr)
+	assert.Must(err != nil)
+	assert.Must(p.Stats().InUse == 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.MustNoError(p.Drain(ctx))
+}
+
+func TestPoolRemove(t *testing.T) {
+	s1 := newOKServer()
+	defer s1.Close()
+	s2 := newOKServer()
+	defer s2.Close()
+
+	p := NewPool("", time.Minute)
+
+	for _, addr := range []string{s1.Addr(), s1.Addr(), s2.Addr()} {
+		c, err := p.GetClient(addr)
+		assert.MustNoError(err)
+		defer p.PutClient(c)
+	}
+	c, err := p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+
+	assert.MustNoError(p.Remove(s1.Addr()))
+	assert.Must(c.conn.Err() != nil)
+	assert.MustNoError(p.Remove("127.0.0.1:0"))
+
+	stats := p.Stats()
+	assert.Must(stats.Idle == 0 && stats.InUse == 3)
+	assert.Must(stats.PerAddr[s1.Addr()].Active == 2)
+
+	p.Close()
+	assert.Must(errors.Equal(p.Remove(s1.Addr()), ErrClosedPool))
+}
+
+func TestClientDialErrors(t *testing.T) {
+	var auths atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		auths.Incr()
+		return resp.NewError([]byte("ERR invalid password"))
+	})
+	defer s.Close()
+
+	_, err := NewClient(s.Addr(), "foobar", time.Second)
+	assert.Must(errors.Cause(err) == ErrRedisAuth && IsAuthError(err))
+	assert.Must(err.Error() == "auth redis failed: ERR invalid password")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = NewClient(addr, "", time.Second)
+	assert.Must(errors.Cause(err) == ErrRedisDial && !IsAuthError(err))
+	assert.Must(strings.HasPrefix(err.Error(), "dial redis failed: ") && strings.Contains(err.Error(), addr))
+
+	p := NewPool("foobar", time.Second)
+	defer p.Close()
+
+	p.SetDialRetry(3, time.Millisecond)
+	_, err = p.GetClient(s.Addr())
+	assert.Must(errors.Cause(err) == ErrRedisAuth)
+	assert.Must(auths.Int64() == 2)
+}
+
+func TestClientAuthTimeout(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Millisecond * 200)
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	start := time.Now()
+	_, err := NewClientWithOption(s.Addr(), &ClientOption{
+		Auth: "foobar", Timeout: time.Second, DialTimeout: time.Millisecond * 50,
+	})
+	assert.Must(errors.Cause(err) == ErrRedisAuthTimeout)
+	assert.Must(time.Since(start) < time.Millisecond*200)
+	assert.Must(IsRetryable(err) && IsAuthError(err))
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codis")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newFakeServerOn("unix", filepath.Join(dir, "redis.sock"), func(args []string) *resp.Resp {
+		return resp.NewString([]byte("PONG"))
+	})
+	defer s.Close()
+
+	network, path := dialNetwork(s.Addr())
+	assert.Must(network == "unix" && path == s.Addr())
+	network, path = dialNetwork("unix:" + s.Addr())
+	assert.Must(network == "unix" && path == s.Addr())
+	network, _ = dialNetwork("127.0.0.1:6379")
+	assert.Must(network == "tcp")
+
+	p := NewPool("foobar", time.Minute)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.MustNoError(c.Ping())
+	p.PutClient(c)
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(p.Stats().Hits == 1)
+	c.Close()
+	p.PutClient(c)
+
+	c, err = p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.MustNoError(c.Ping())
+	p.PutClient(c)
+	assert.Must(p.Stats().Created == 2)
+
+	c, err = p.GetClient("unix:" + s.Addr())
+	assert.MustNoError(err)
+	assert.MustNoError(c.Ping())
+	assert.Must(c.SetMaster(s.Addr()) != nil)
+	assert.Must(c.SetMaster("unix:"+s.Addr()) != nil)
+	p.PutClient(c)
+}
+
+func TestPoolInfoMany(t *testing.T) {
+	var running, peak atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		n := running.Incr()
+		defer running.Decr()
+		for {
+			if x := peak.Int64(); x >= n || peak.CompareAndSwap(x, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+		return resp.NewBulkBytes([]byte("role:master\r\n"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	dead := l.Addr().String()
+	l.Close()
+
+	var addrs = []string{dead}
+	for i := 0; i < 8; i++ {
+		addrs = append(addrs, s.Addr())
+	}
+	infos, errs := p.InfoMany(addrs, 3)
+	assert.Must(len(infos) == 1 && infos[s.Addr()]["role"] == "master")
+	assert.Must(len(errs) == 1 && errs[dead] != nil)
+	assert.Must(peak.Int64() <= 3)
+}
+
+func TestClientKeyspaceStats(t *testing.T) {
+	s := newInfoServer(
+		"# Keyspace",
+		"db0:keys=10,expires=2,avg_ttl=300",
+		"db2:keys=1,expires=0,avg_ttl=0,subexpiry=0",
+		"dbx:keys=1",
+	)
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	keyspace, err := c.KeyspaceStats()
+	assert.MustNoError(err)
+	assert.Must(len(keyspace) == 2)
+	assert.Must(keyspace[0] == KeyspaceStat{Keys: 10, Expires: 2, AvgTTL: 300})
+	assert.Must(keyspace[2] == KeyspaceStat{Keys: 1})
+}
+
+func TestClientWaitForSync(t *testing.T) {
+	var syncing atomic2.Int64
+	syncing.Set(3)
+	s1 := newFakeServer(func(args []string) *resp.Resp {
+		var lines = []string{"role:slave", "master_link_status:up", "master_sync_in_progress:0"}
+		if syncing.Decr() >= 0 {
+			lines = []string{"role:slave", "master_link_status:down", "master_sync_in_progress:1"}
+		}
+		return resp.NewBulkBytes([]byte(strings.Join(lines, "\r\n")))
+	})
+	defer s1.Close()
+	s2 := newInfoServer("role:slave", "master_link_status:down", "master_sync_in_progress:1")
+	defer s2.Close()
+	s3 := newInfoServer("role:master", "connected_slaves:0")
+	defer s3.Close()
+
+	var clients []*Client
+	for _, addr := range []string{s1.Addr(), s2.Addr(), s3.Addr()} {
+		c, err := NewClient(addr, "", time.Second)
+		assert.MustNoError(err)
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	assert.MustNoError(clients[0].WaitForSync(ctx, time.Millisecond))
+	assert.Must(errors.Equal(clients[1].WaitForSync(ctx, time.Millisecond*10), context.DeadlineExceeded))
+	assert.Must(errors.Equal(clients[2].WaitForSync(ctx, time.Millisecond), ErrNotSlave))
+}
+
+func TestClientSlowLog(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		if args[1] == "RESET" {
+			return resp.NewString([]byte("OK"))
+		}
+		return resp.NewArray([]*resp.Resp{
+			resp.NewArray([]*resp.Resp{
+				resp.NewInt([]byte("14")), resp.NewInt([]byte("1309448221")),
+				resp.NewInt([]byte("15")), newBulkArray("SLOTSMGRTTAGSLOT", "127.0.0.1", "6380"),
+				resp.NewBulkBytes([]byte("127.0.0.1:58217")), resp.NewBulkBytes([]byte("codis")),
+			}),
+			resp.NewArray([]*resp.Resp{
+				resp.NewInt([]byte("13")), resp.NewInt([]byte("1309448128")),
+				resp.NewInt([]byte("30")), newBulkArray("KEYS", "*"),
+			}),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	entries, err := c.SlowLog(10)
+	assert.MustNoError(err)
+	assert.Must(<-command == "SLOWLOG GET 10")
+	assert.Must(len(entries) == 2)
+	assert.Must(entries[0].Id == 14 && entries[0].MicroSeconds == 15)
+	assert.Must(strings.Join(entries[0].Command, " ") == "SLOTSMGRTTAGSLOT 127.0.0.1 6380")
+	assert.Must(entries[0].ClientAddr == "127.0.0.1:58217" && entries[0].ClientName == "codis")
+	assert.Must(entries[1].Timestamp == 1309448128 && entries[1].ClientAddr == "")
+
+	assert.MustNoError(c.SlowLogReset())
+	assert.Must(<-command == "SLOWLOG RESET")
+}
+
+func newSlaveOfServer(apply bool) *fakeServer {
+	var mu sync.Mutex
+	var master []string
+	var queued int
+	return newFakeServer(func(args []string) *resp.Resp {
+		mu.Lock()
+		defer mu.Unlock()
+		switch args[0] {
+		case "MULTI":
+			return resp.NewString([]byte("OK"))
+		case "EXEC":
+			var array []*resp.Resp
+			for ; queued != 0; queued-- {
+				array = append(array, resp.NewString([]byte("OK")))
+			}
+			return resp.NewArray(array)
+		case "SLAVEOF":
+			if apply {
+				master = args[1:]
+			}
+			fallthrough
+		case "CONFIG", "CLIENT":
+			queued++
+			return resp.NewString([]byte("QUEUED"))
+		case "INFO":
+			var lines = []string{"role:master"}
+			if len(master) != 0 && strings.ToUpper(master[0]) != "NO" {
+				lines = []string{"role:slave", "master_host:" + master[0], "master_port:" + master[1]}
+			}
+			return resp.NewBulkBytes([]byte(strings.Join(lines, "\r\n")))
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+}
+
+func TestClientSetMasterAndVerify(t *testing.T) {
+	s1 := newSlaveOfServer(true)
+	defer s1.Close()
+	s2 := newSlaveOfServer(false)
+	defer s2.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	assert.MustNoError(c1.SetMasterAndVerify("127.0.0.1:6380", time.Second))
+	assert.MustNoError(c1.SetMasterAndVerify("NO:ONE", time.Second))
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	assert.Must(c2.SetMasterAndVerify("127.0.0.1:6380", time.Millisecond*50) != nil)
+}
+
+func TestPoolStartReaper(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	c.Close()
+
+	stop1 := p.StartReaper(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 100)
+	assert.Must(p.Stats().ClosedOnCleanup == 1)
+
+	stop2 := p.StartReaper(time.Millisecond * 10)
+	stop1()
+	p.mu.Lock()
+	assert.Must(p.cleanup.C != nil)
+	p.mu.Unlock()
+
+	stop2()
+	p.mu.Lock()
+	assert.Must(p.cleanup.C == nil)
+	p.mu.Unlock()
+
+	p.Close()
+	p.StartReaper(time.Millisecond)()
+}
+
+func TestClientKillClient(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		if args[3] == "127.0.0.1:52555" || args[3] == "3" {
+			return resp.NewInt([]byte("1"))
+		}
+		return resp.NewInt([]byte("0"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	ok, err := c.KillClient("127.0.0.1:52555")
+	assert.MustNoError(err)
+	assert.Must(ok && <-command == "CLIENT KILL ADDR 127.0.0.1:52555")
+
+	ok, err = c.KillClient("127.0.0.1:52556")
+	assert.MustNoError(err)
+	assert.Must(!ok && <-command == "CLIENT KILL ADDR 127.0.0.1:52556")
+
+	ok, err = c.KillClientById(3)
+	assert.MustNoError(err)
+	assert.Must(ok && <-command == "CLIENT KILL ID 3")
+
+	_, err = c.KillClient("127.0.0.1")
+	assert.Must(err != nil && len(command) == 0)
+}
+
+func newRoleServer(role string, calls *atomic2.Int64) *fakeServer {
+	return newFakeServer(func(args []string) *resp.Resp {
+		calls.Incr()
+		return resp.NewArray([]*resp.Resp{resp.NewBulkBytes([]byte(role))})
+	})
+}
+
+func TestPoolGetReplicaClient(t *testing.T) {
+	var calls atomic2.Int64
+	s0 := newRoleServer("master", &calls)
+	defer s0.Close()
+	s1 := newRoleServer("slave", &calls)
+	defer s1.Close()
+	s2 := newRoleServer("slave", &calls)
+	defer s2.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	var group = []string{s0.Addr(), s1.Addr(), s2.Addr()}
+	var picked = make(map[string]int)
+	for i := 0; i < 4; i++ {
+		c, err := p.GetReplicaClient(group)
+		assert.MustNoError(err)
+		picked[c.Addr]++
+		p.PutClient(c)
+	}
+	assert.Must(picked[s1.Addr()] == 2 && picked[s2.Addr()] == 2)
+	assert.Must(calls.Int64() == 3)
+
+	c, err := p.GetReplicaClient(group[:1])
+	assert.MustNoError(err)
+	assert.Must(c.Addr == s0.Addr())
+	p.PutClient(c)
+
+	p.SetRoleTTL(0)
+	_, err = p.Role(s0.Addr())
+	assert.MustNoError(err)
+	assert.Must(calls.Int64() == 4)
+
+	_, err = p.GetReplicaClient([]string{"127.0.0.1:0"})
+	assert.Must(err != nil)
+}
+
+func TestClientBgSave(t *testing.T) {
+	var lastsave atomic2.Int64
+	lastsave.Set(1500000000)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "BGSAVE":
+			lastsave.Incr()
+			return resp.NewString([]byte("Background saving started"))
+		case "LASTSAVE":
+			return resp.NewInt([]byte(strconv.FormatInt(lastsave.Int64(), 10)))
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	t1, err := c.LastSave()
+	assert.MustNoError(err)
+	assert.Must(t1.Unix() == 1500000000)
+
+	assert.MustNoError(c.BgSave())
+	t2, err := c.LastSave()
+	assert.MustNoError(err)
+	assert.Must(t2.After(t1))
+}
+
+func TestClientDebugSleep(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	err = c.DebugSleep(0.5)
+	assert.Must(errors.Equal(err, ErrDebugDisabled))
+	assert.Must(len(command) == 0)
+
+	EnableDebugCommands(true)
+	defer EnableDebugCommands(false)
+
+	assert.MustNoError(c.DebugSleep(0.5))
+	assert.Must(<-command == "DEBUG SLEEP 0.5")
+}
+
+func TestClientWaitForBgSave(t *testing.T) {
+	var calls atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "INFO":
+			if calls.Incr() <= 2 {
+				return resp.NewBulkBytes([]byte("# Persistence\r\nrdb_bgsave_in_progress:1\r\n"))
+			}
+			return resp.NewBulkBytes([]byte("# Persistence\r\nrdb_bgsave_in_progress:0\r\n"))
+		case "LASTSAVE":
+			return resp.NewInt([]byte("1500000001"))
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	running, err := c.RdbSaveInProgress()
+	assert.MustNoError(err)
+	assert.Must(running)
+
+	assert.MustNoError(c.WaitForBgSave(context.Background(), time.Unix(1500000000, 0), time.Millisecond))
+	assert.Must(calls.Int64() == 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = c.WaitForBgSave(ctx, time.Unix(1500000001, 0), time.Millisecond)
+	assert.Must(errors.Equal(err, context.DeadlineExceeded))
+}
+
+func TestClientShutdown(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		if args[1] == "SAVE" {
+			return resp.NewErrorf("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+		return nil
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	assert.Must(c.ShutdownSave(true) != nil)
+	assert.Must(<-command == "SHUTDOWN SAVE")
+	assert.Must(c.isRecyclable() && c.Healthy())
+
+	assert.MustNoError(c.ShutdownSave(false))
+	assert.Must(<-command == "SHUTDOWN NOSAVE")
+	assert.Must(!c.isRecyclable() && !c.Healthy())
+
+	p.PutClient(c)
+	assert.Must(p.Stats().Idle == 0)
+}
+
+func TestClientShutdownTimeout(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Second)
+		return nil
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Millisecond*100)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	err = c.ShutdownSave(true)
+	assert.Must(err != nil)
+	if err, ok := errors.Cause(err).(net.Error); ok {
+		assert.Must(err.Timeout())
+	} else {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestClientMigrateAsyncRunning(t *testing.T) {
+	var running atomic2.Bool
+	s1 := newFakeServer(func(args []string) *resp.Resp {
+		switch args[0] {
+		case "SLOTSMGRT-ASYNC-STATUS":
+			if !running.IsTrue() {
+				return resp.NewArray(nil)
+			}
+			return newBulkArray("host", "127.0.0.1")
+		case "SLOTSMGRT-ASYNC-CANCEL":
+			if running.IsTrue() {
+				running.Set(false)
+				return resp.NewInt([]byte("1"))
+			}
+			return resp.NewInt([]byte("0"))
+		}
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s1.Close()
+	s2 := newFakeServer(func(args []string) *resp.Resp {
+		return resp.NewErrorf("ERR unknown command '%s'", args[0])
+	})
+	defer s2.Close()
+
+	c1, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c1.Close()
+
+	ok, err := c1.MigrateAsyncRunning()
+	assert.MustNoError(err)
+	assert.Must(!ok)
+
+	running.Set(true)
+	ok, err = c1.MigrateAsyncRunning()
+	assert.MustNoError(err)
+	assert.Must(ok)
+
+	ok, err = c1.MigrateSlotAsyncCancel()
+	assert.MustNoError(err)
+	assert.Must(ok)
+	ok, err = c1.MigrateSlotAsyncCancel()
+	assert.MustNoError(err)
+	assert.Must(!ok)
+
+	c2, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c2.Close()
+
+	_, err = c2.MigrateAsyncRunning()
+	assert.Must(errors.Equal(err, ErrUnsupported))
+	_, err = c2.MigrateSlotAsyncCancel()
+	assert.Must(errors.Equal(err, ErrUnsupported))
+}
+
+func TestClientFlush(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	assert.Must(errors.Equal(c.FlushDB(nil), ErrFlushNotConfirmed))
+	assert.Must(errors.Equal(c.FlushAll(&FlushOption{Async: true}), ErrFlushNotConfirmed))
+	assert.Must(len(command) == 0)
+
+	assert.MustNoError(c.FlushDB(&FlushOption{Confirm: true}))
+	assert.Must(<-command == "FLUSHDB")
+	assert.MustNoError(c.FlushAll(&FlushOption{Async: true, Confirm: true}))
+	assert.Must(<-command == "FLUSHALL ASYNC")
+}
+
+func TestPoolWarmup(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	dead := l.Addr().String()
+	l.Close()
+
+	errs := p.Warmup([]string{s.Addr(), dead}, 3)
+	assert.Must(len(errs) == 1 && errs[dead] != nil)
+
+	stats := p.Stats()
+	assert.Must(stats.Idle == 3 && stats.InUse == 0)
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	assert.Must(p.Stats().Hits == 1)
+
+	p.Close()
+	errs = p.Warmup([]string{s.Addr()}, 1)
+	assert.Must(errors.Equal(errs[s.Addr()], ErrClosedPool))
+}
+
+func TestPoolWarmupMaxActive(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetMaxActive(2, 0)
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+
+	var done = make(chan map[string]error, 1)
+	go func() {
+		done <- p.Warmup([]string{s.Addr()}, 5)
+	}()
+	select {
+	case errs := <-done:
+		assert.Must(len(errs) == 0)
+	case <-time.After(time.Second * 5):
+		assert.Must(false)
+	}
+	p.PutClient(c)
+
+	stats := p.Stats()
+	assert.Must(stats.Idle == 2 && stats.Active == 2 && stats.InUse == 0)
+}
+
+func TestClientRoleInfo(t *testing.T) {
+	s1 := newFakeServer(func(args []string) *resp.Resp {
+		return resp.NewArray([]*resp.Resp{
+			resp.NewBulkBytes([]byte("master")), resp.NewInt([]byte("3129659")),
+			resp.NewArray([]*resp.Resp{
+				newBulkArray("10.0.0.2", "6380", "3129242"),
+				newBulkArray("10.0.0.3", "6380", "3129543"),
+			}),
+		})
+	})
+	defer s1.Close()
+	s2 := newFakeServer(func(args []string) *resp.Resp {
+		return resp.NewArray([]*resp.Resp{
+			resp.NewBulkBytes([]byte("slave")), resp.NewBulkBytes([]byte("10.0.0.1")),
+			resp.NewInt([]byte("6379")), resp.NewBulkBytes([]byte("connected")),
+			resp.NewInt([]byte("3167038")),
+		})
+	})
+	defer s2.Close()
+	s3 := newFakeServer(func(args []string) *resp.Resp {
+		if args[0] != "INFO" {
+			return resp.NewErrorf("ERR unknown command '%s'", args[0])
+		}
+		return resp.NewBulkBytes([]byte(strings.Join([]string{
+			"role:slave", "master_host:10.0.0.1", "master_port:6379", "slave_repl_offset:42",
+		}, "\r\n")))
+	})
+	defer s3.Close()
+
+	var roles []*RoleInfo
+	for _, addr := range []string{s1.Addr(), s2.Addr(), s3.Addr()} {
+		c, err := NewClient(addr, "", time.Second)
+		assert.MustNoError(err)
+		defer c.Close()
+		r, err := c.RoleInfo()
+		assert.MustNoError(err)
+		roles = append(roles, r)
+	}
+
+	assert.Must(roles[0].Role == "master" && roles[0].ReplOffset == 3129659)
+	assert.Must(len(roles[0].Slaves) == 2)
+	assert.Must(roles[0].Slaves[1] == RoleSlave{Host: "10.0.0.3", Port: "6380", Offset: 3129543})
+
+	assert.Must(roles[1].Role == "slave" && roles[1].ReplOffset == 3167038)
+	assert.Must(roles[1].MasterHost == "10.0.0.1" && roles[1].MasterPort == "6379")
+
+	assert.Must(roles[2].Role == "slave" && roles[2].ReplOffset == 42)
+	assert.Must(roles[2].MasterHost == "10.0.0.1" && roles[2].MasterPort == "6379")
+}
+
+func TestClientWaitForCatchUp(t *testing.T) {
+	var offset atomic2.Int64
+	s1 := newInfoServer("role:master", "master_repl_offset:5000")
+	defer s1.Close()
+	s2 := newFakeServer(func(args []string) *resp.Resp {
+		n := offset.Add(1000)
+		return resp.NewBulkBytes([]byte(strings.Join([]string{
+			"role:slave", "master_link_status:up", "slave_repl_offset:" + strconv.FormatInt(n, 10),
+		}, "\r\n")))
+	})
+	defer s2.Close()
+
+	master, err := NewClient(s1.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer master.Close()
+
+	slave, err := NewClient(s2.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer slave.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.MustNoError(slave.WaitForCatchUp(ctx, master, 1000, time.Millisecond))
+	assert.Must(offset.Int64() == 4000)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	err = master.WaitForCatchUp(ctx, master, 0, time.Millisecond)
+	assert.Must(errors.Equal(err, ErrNotSlave))
+
+	offset.Set(-1 << 20)
+	err = slave.WaitForCatchUp(ctx, master, 0, time.Millisecond*5)
+	assert.Must(err != nil && strings.Contains(err.Error(), "lag = "))
+}
+
+func newSelfSignedCert() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.MustNoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.MustNoError(err)
+	cert, err := x509.ParseCertificate(der)
+	assert.MustNoError(err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestPoolTLS(t *testing.T) {
+	cert, roots := newSelfSignedCert()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	s1 := newFakeServerListener(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}), func(args []string) *resp.Resp {
+		return resp.NewString([]byte("PONG"))
+	})
+	defer s1.Close()
+	s2 := newOKServer()
+	defer s2.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	p.SetTLS(true, &tls.Config{RootCAs: roots})
+	c, err := p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	assert.MustNoError(c.Ping())
+	p.PutClient(c)
+
+	p.SetTLS(true, nil)
+	_, err = p.GetClient(s2.Addr())
+	assert.Must(err != nil)
+
+	p.SetTLS(false, nil)
+	c, err = p.GetClient(s2.Addr())
+	assert.MustNoError(err)
+	assert.MustNoError(c.Ping())
+	p.PutClient(c)
+}
+
+func TestClientValidateSlot(t *testing.T) {
+	var calls atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		calls.Incr()
+		return resp.NewArray([]*resp.Resp{
+			resp.NewArray([]*resp.Resp{
+				resp.NewInt([]byte("1024")), resp.NewInt([]byte("1")),
+			}),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	for _, slot := range []int{-1, MaxSlotNum} {
+		_, err = c.MigrateSlot(slot, "127.0.0.1:6380")
+		assert.Must(err != nil)
+		_, err = c.MigrateSlotNoTag(slot, "127.0.0.1:6380")
+		assert.Must(err != nil)
+		_, err = c.MigrateSlotAsync(slot, "127.0.0.1:6380", &MigrateSlotAsyncOption{})
+		assert.Must(err != nil)
+		_, _, err = c.SlotsScan(slot, 0, 10)
+		assert.Must(err != nil)
+	}
+	assert.Must(calls.Int64() == 0)
+
+	_, err = c.SlotsInfo()
+	assert.Must(err != nil)
+}
+
+func TestPoolWithClient(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	assert.MustNoError(p.WithClient(s.Addr(), func(c *Client) error {
+		return c.Ping()
+	}))
+	assert.Must(p.Stats().Idle == 1 && p.Stats().InUse == 0)
+
+	err := p.WithClient(s.Addr(), func(c *Client) error {
+		c.Close()
+		return errors.New("broken")
+	})
+	assert.Must(err != nil && err.Error() == "broken")
+
+	stats := p.Stats()
+	assert.Must(stats.Idle == 0 && stats.InUse == 0 && stats.ClosedOnError == 1)
+}
+
+func TestPoolGroupHealth(t *testing.T) {
+	s1 := newOKServer()
+	defer s1.Close()
+	s2 := newFakeServer(func(args []string) *resp.Resp {
+		return nil
+	})
+	defer s2.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	c, err := p.GetClient(s1.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	dead := l.Addr().String()
+	l.Close()
+
+	health := p.GroupHealth([]string{s1.Addr(), s2.Addr(), dead})
+	assert.Must(len(health) == 3)
+	assert.Must(health[s1.Addr()] == nil)
+	assert.Must(health[s2.Addr()] != nil && health[dead] != nil)
+
+	stats := p.Stats()
+	assert.Must(stats.Hits == 1 && stats.Idle == 1)
+	x := stats.PerAddr[s2.Addr()]
+	assert.Must(x.Idle == 0 && x.Active == 0)
+}
+
+func TestPoolSetGroupTopology(t *testing.T) {
+	s1 := newSlaveOfServer(true)
+	defer s1.Close()
+	s2 := newSlaveOfServer(true)
+	defer s2.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	dead := l.Addr().String()
+	l.Close()
+
+	result := p.SetGroupTopology(s1.Addr(), []string{s2.Addr(), dead})
+	assert.Must(len(result) == 3)
+	assert.Must(result[s1.Addr()] == nil && result[s2.Addr()] == nil)
+	assert.Must(result[dead] != nil)
+
+	c, err := p.GetClient(s2.Addr())
+	assert.MustNoError(err)
+	defer p.PutClient(c)
+
+	r, err := c.ReplicationInfo()
+	assert.MustNoError(err)
+	assert.Must(r.Role == "slave" && r.MasterAddr() == s1.Addr())
+}
+
+func TestPoolDoWithRetry(t *testing.T) {
+	var calls atomic2.Int64
+	s := newFakeServer(func(args []string) *resp.Resp {
+		switch {
+		case args[0] == "LPUSH":
+			return resp.NewErrorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		case calls.Incr() < 3:
+			return nil
+		}
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	var attempts int
+	err := p.DoWithRetry(s.Addr(), 5, time.Millisecond, func(c *Client) error {
+		attempts++
+		_, err := c.Do("SET", "key", "value")
+		return err
+	})
+	assert.MustNoError(err)
+	assert.Must(attempts == 3)
+
+	attempts = 0
+	err = p.DoWithRetry(s.Addr(), 5, time.Millisecond, func(c *Client) error {
+		attempts++
+		_, err := c.Do("LPUSH", "key", "value")
+		return err
+	})
+	assert.Must(err != nil && !IsRetryable(err))
+	assert.Must(attempts == 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	dead := l.Addr().String()
+	l.Close()
+
+	attempts = 0
+	err = p.DoWithRetry(dead, 3, time.Millisecond, func(c *Client) error {
+		attempts++
+		return nil
+	})
+	assert.Must(errors.Equal(err, ErrRedisDial) && attempts == 0)
+	assert.Must(p.Stats().DialFailures == 3)
+
+	assert.Must(!IsRetryable(nil) && !IsRetryable(ErrRedisAuth))
+}
+
+func TestPoolCircuitBreaker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetCircuitBreaker(2, time.Minute, time.Millisecond*50)
+
+	for i := 0; i < 2; i++ {
+		_, err := p.GetClient(addr)
+		assert.Must(errors.Equal(err, ErrRedisDial))
+	}
+	_, err = p.GetClient(addr)
+	assert.Must(errors.Equal(err, ErrBackendUnavailable))
+	assert.Must(p.Stats().Dials == 2)
+	assert.Must(p.Stats().PerAddr[addr].Breaker == BreakerOpen)
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Must(p.Stats().PerAddr[addr].Breaker == BreakerHalfOpen)
+	_, err = p.GetClient(addr)
+	assert.Must(errors.Equal(err, ErrRedisDial))
+	_, err = p.GetClient(addr)
+	assert.Must(errors.Equal(err, ErrBackendUnavailable))
+	assert.Must(p.Stats().Dials == 3)
+
+	s := newFakeServerOn("tcp", addr, func(args []string) *resp.Resp {
+		return resp.NewString([]byte("OK"))
+	})
+	defer s.Close()
+
+	time.Sleep(time.Millisecond * 60)
+	c, err := p.GetClient(addr)
+	assert.MustNoError(err)
+	p.PutClient(c)
+	assert.Must(p.Stats().PerAddr[addr].Breaker == "")
+}
+
+func TestPoolNoReuse(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetNoReuse(true)
+	for i := 0; i < 3; i++ {
+		c, err := p.GetClient(s.Addr())
+		assert.MustNoError(err)
+		p.PutClient(c)
+		assert.Must(!c.Healthy())
+	}
+	stats := p.Stats()
+	assert.Must(stats.Hits == 0 && stats.Dials == 3 && stats.Created == 3)
+	assert.Must(stats.Closed == 3 && stats.Idle == 0 && stats.Active == 0)
+
+	p.SetNoReuse(false)
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	p.PutClient(c)
+	assert.Must(c.Healthy() && p.Stats().Idle == 1)
+}
+
+func TestClientMigrateSlotWithLimit(t *testing.T) {
+	var command = make(chan string, 1)
+	s := newFakeServer(func(args []string) *resp.Resp {
+		command <- strings.Join(args, " ")
+		return resp.NewArray([]*resp.Resp{
+			resp.NewInt([]byte("10")), resp.NewInt([]byte("90")),
+		})
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.Addr(), "", time.Second)
+	assert.MustNoError(err)
+	defer c.Close()
+
+	n, err := c.MigrateSlotWithLimit(7, "127.0.0.1:6380", 10)
+	assert.MustNoError(err)
+	assert.Must(n == 90)
+	assert.Must(<-command == "SLOTSMGRTTAGSLOT-ASYNC 127.0.0.1 6380 1000 0 0 7 10")
+
+	_, err = c.MigrateSlotWithLimit(7, "127.0.0.1:6380", 0)
+	assert.Must(err != nil && len(command) == 0)
+}
+
+func TestPoolTCPOptions(t *testing.T) {
+	s := newOKServer()
+	defer s.Close()
+
+	p := NewPool("", time.Second)
+	defer p.Close()
+
+	assert.Must(p.option.KeepAlive == DefaultKeepAlive && !p.option.DisableNoDelay)
+
+	for _, keepAlive := range []time.Duration{-1, time.Second * 10} {
+		for _, noDelay := range []bool{false, true} {
+			p.SetTCPOptions(keepAlive, noDelay)
+			assert.Must(p.option.KeepAlive == keepAlive && p.option.DisableNoDelay == !noDelay)
+
+			c, err := p.GetClient(s.Addr())
+			assert.MustNoError(err)
+			assert.MustNoError(c.Ping())
+			c.Close()
+			p.PutClient(c)
+		}
+	}
+}
+
+func TestPoolCircuitBreakerWindow(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetCircuitBreaker(2, time.Millisecond*20, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.GetClient(addr)
+		assert.Must(errors.Equal(err, ErrRedisDial))
+		time.Sleep(time.Millisecond * 30)
+	}
+	assert.Must(p.Stats().PerAddr[addr] == nil)
+
+	p.SetCircuitBreaker(0, 0, 0)
+	for i := 0; i < 3; i++ {
+		_, err := p.GetClient(addr)
+		assert.Must(errors.Equal(err, ErrRedisDial))
+	}
+	assert.Must(p.Stats().Dials == 6)
+}
+
+func TestPoolInfoManyContext(t *testing.T) {
+	s := newFakeServer(func(args []string) *resp.Resp {
+		time.Sleep(time.Millisecond * 50)
+		return resp.NewBulkBytes([]byte("role:master\r\n"))
+	})
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	var pending = "127.0.0.1:0"
+	infos, errs := p.InfoManyContext(ctx, []string{s.Addr(), pending}, 1)
+	assert.Must(len(infos) == 0 && len(errs) == 2)
+	assert.Must(errors.Equal(errs[pending], context.DeadlineExceeded))
+	assert.Must(p.Stats().Active == 0)
+
+	infos, errs = p.InfoManyContext(context.Background(), []string{s.Addr()}, 1)
+	assert.Must(len(infos) == 1 && len(errs) == 0)
+}
+
+func TestPoolInfoManyContextMaxActive(t *testing.T) {
+	s := newInfoServer("role:master")
+	defer s.Close()
+
+	p := NewPool("", time.Minute)
+	defer p.Close()
+
+	p.SetMaxActive(1, 0)
+
+	c, err := p.GetClient(s.Addr())
+	assert.MustNoError(err)
+	defer p.PutClient(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	infos, errs := p.InfoManyContext(ctx, []string{s.Addr()}, 1)
+	assert.Must(len(infos) == 0 && len(errs) == 1)
+	assert.Must(errors.Equal(errs[s.Addr()], context.DeadlineExceeded))
+	assert.Must(time.Since(start) < time.Second)
+	assert.Must(p.Stats().InUse == 1)
+}
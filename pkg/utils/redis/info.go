@@ -0,0 +1,574 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/math2"
+
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+type KeyspaceStat struct {
+	Keys    int64 `json:"keys"`
+	Expires int64 `json:"expires"`
+	AvgTTL  int64 `json:"avg_ttl"`
+}
+
+type ServerInfo struct {
+	Role            string `json:"role"`
+	UptimeInSeconds int64  `json:"uptime_in_seconds"`
+
+	UsedMemory int64 `json:"used_memory"`
+	MaxMemory  int64 `json:"maxmemory"`
+
+	ConnectedSlaves  int    `json:"connected_slaves"`
+	MasterLinkStatus string `json:"master_link_status,omitempty"`
+
+	Keyspace map[int]KeyspaceStat `json:"keyspace,omitempty"`
+}
+
+func NewServerInfo(info map[string]string) *ServerInfo {
+	s := &ServerInfo{
+		Role:            info["role"],
+		UptimeInSeconds: parseInfoInt(info, "uptime_in_seconds"),
+
+		UsedMemory: parseInfoInt(info, "used_memory"),
+		MaxMemory:  parseInfoInt(info, "maxmemory"),
+
+		ConnectedSlaves:  int(parseInfoInt(info, "connected_slaves")),
+		MasterLinkStatus: info["master_link_status"],
+
+		Keyspace: parseKeyspace(info),
+	}
+	return s
+}
+
+func (c *Client) ServerInfo() (*ServerInfo, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewServerInfo(info), nil
+}
+
+func (c *Client) KeyspaceStats() (map[int]KeyspaceStat, error) {
+	info, err := c.InfoSection("keyspace")
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyspace(info), nil
+}
+
+type MemoryStats struct {
+	UsedMemory    int64 `json:"used_memory"`
+	UsedMemoryRss int64 `json:"used_memory_rss"`
+	MaxMemory     int64 `json:"maxmemory"`
+
+	MemFragmentationRatio float64 `json:"mem_fragmentation_ratio"`
+
+	EvictedKeys int64 `json:"evicted_keys"`
+}
+
+func NewMemoryStats(info map[string]string) (*MemoryStats, error) {
+	var m = &MemoryStats{}
+	for _, x := range []struct {
+		key string
+		ptr *int64
+	}{
+		{"used_memory", &m.UsedMemory},
+		{"used_memory_rss", &m.UsedMemoryRss},
+		{"maxmemory", &m.MaxMemory},
+		{"evicted_keys", &m.EvictedKeys},
+	} {
+		n, err := strconv.ParseInt(info[x.key], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid info field %s = %q", x.key, info[x.key])
+		}
+		*x.ptr = n
+	}
+	f, err := strconv.ParseFloat(info["mem_fragmentation_ratio"], 64)
+	if err != nil {
+		return nil, errors.Errorf("invalid info field mem_fragmentation_ratio = %q", info["mem_fragmentation_ratio"])
+	}
+	m.MemFragmentationRatio = f
+	return m, nil
+}
+
+func (c *Client) MemoryStats() (*MemoryStats, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewMemoryStats(info)
+}
+
+// UsageRatio returns used_memory / maxmemory, or 0 if maxmemory isn't set,
+// i.e. the memory is unlimited.
+func (m *MemoryStats) UsageRatio() float64 {
+	if m.MaxMemory <= 0 {
+		return 0
+	}
+	return float64(m.UsedMemory) / float64(m.MaxMemory)
+}
+
+// MemoryUsage returns used_memory, maxmemory and their ratio with a single
+// INFO, see MemoryStats.UsageRatio for the case maxmemory is 0. Unlike
+// MemoryStats, only these two fields are required.
+func (c *Client) MemoryUsage() (used, max int64, ratio float64, err error) {
+	info, err := c.InfoSection("memory")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var m = &MemoryStats{}
+	for _, x := range []struct {
+		key string
+		ptr *int64
+	}{
+		{"used_memory", &m.UsedMemory},
+		{"maxmemory", &m.MaxMemory},
+	} {
+		n, err := strconv.ParseInt(info[x.key], 10, 64)
+		if err != nil {
+			return 0, 0, 0, errors.Errorf("invalid info field %s = %q", x.key, info[x.key])
+		}
+		*x.ptr = n
+	}
+	return m.UsedMemory, m.MaxMemory, m.UsageRatio(), nil
+}
+
+type SlaveInfo struct {
+	Addr   string `json:"addr"`
+	State  string `json:"state"`
+	Offset int64  `json:"offset"`
+	Lag    int64  `json:"lag"`
+}
+
+type ReplicationInfo struct {
+	Role string `json:"role"`
+
+	MasterHost       string `json:"master_host,omitempty"`
+	MasterPort       string `json:"master_port,omitempty"`
+	MasterLinkStatus string `json:"master_link_status,omitempty"`
+
+	MasterSyncInProgress bool `json:"master_sync_in_progress,omitempty"`
+
+	MasterReplOffset int64 `json:"master_repl_offset"`
+	SlaveReplOffset  int64 `json:"slave_repl_offset,omitempty"`
+
+	Slaves []SlaveInfo `json:"slaves,omitempty"`
+}
+
+func NewReplicationInfo(info map[string]string) *ReplicationInfo {
+	r := &ReplicationInfo{
+		Role: info["role"],
+
+		MasterHost:       info["master_host"],
+		MasterPort:       info["master_port"],
+		MasterLinkStatus: info["master_link_status"],
+
+		MasterSyncInProgress: info["master_sync_in_progress"] == "1",
+
+		MasterReplOffset: parseInfoInt(info, "master_repl_offset"),
+		SlaveReplOffset:  parseInfoInt(info, "slave_repl_offset"),
+	}
+	for i := 0; ; i++ {
+		value, ok := info[fmt.Sprintf("slave%d", i)]
+		if !ok {
+			break
+		}
+		var slave SlaveInfo
+		var host, port string
+		for _, field := range strings.Split(value, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "ip":
+				host = kv[1]
+			case "port":
+				port = kv[1]
+			case "state":
+				slave.State = kv[1]
+			case "offset":
+				slave.Offset, _ = strconv.ParseInt(kv[1], 10, 64)
+			case "lag":
+				slave.Lag, _ = strconv.ParseInt(kv[1], 10, 64)
+			}
+		}
+		if host == "" || port == "" {
+			continue
+		}
+		slave.Addr = net.JoinHostPort(host, port)
+		r.Slaves = append(r.Slaves, slave)
+	}
+	return r
+}
+
+func (r *ReplicationInfo) MasterAddr() string {
+	if r.MasterHost == "" && r.MasterPort == "" {
+		return ""
+	}
+	return net.JoinHostPort(r.MasterHost, r.MasterPort)
+}
+
+func (c *Client) ReplicationInfo() (*ReplicationInfo, error) {
+	info, err := c.InfoSection("replication")
+	if err != nil {
+		return nil, err
+	}
+	return NewReplicationInfo(info), nil
+}
+
+type RoleSlave struct {
+	Host   string `json:"host"`
+	Port   string `json:"port"`
+	Offset int64  `json:"offset"`
+}
+
+type RoleInfo struct {
+	Role       string `json:"role"`
+	ReplOffset int64  `json:"repl_offset"`
+
+	MasterHost string `json:"master_host,omitempty"`
+	MasterPort string `json:"master_port,omitempty"`
+
+	Slaves []RoleSlave `json:"slaves,omitempty"`
+}
+
+// RoleInfo parses the reply of ROLE, INFO replication is used instead if the
+// server doesn't support ROLE.
+func (c *Client) RoleInfo() (*RoleInfo, error) {
+	reply, err := c.Do("ROLE")
+	if err != nil {
+		if !errors.Equal(unsupported(err), ErrUnsupported) {
+			return nil, errors.Trace(err)
+		}
+		r, err := c.ReplicationInfo()
+		if err != nil {
+			return nil, err
+		}
+		return newRoleInfo(r), nil
+	}
+	return parseRoleInfo(reply)
+}
+
+func newRoleInfo(r *ReplicationInfo) *RoleInfo {
+	var x = &RoleInfo{
+		Role: r.Role, ReplOffset: r.MasterReplOffset,
+	}
+	if r.Role == "slave" {
+		x.ReplOffset = r.SlaveReplOffset
+		x.MasterHost, x.MasterPort = r.MasterHost, r.MasterPort
+	}
+	for _, slave := range r.Slaves {
+		host, port, err := net.SplitHostPort(slave.Addr)
+		if err != nil {
+			continue
+		}
+		x.Slaves = append(x.Slaves, RoleSlave{
+			Host: host, Port: port, Offset: slave.Offset,
+		})
+	}
+	return x
+}
+
+func parseRoleInfo(reply interface{}) (*RoleInfo, error) {
+	values, err := redigo.Values(reply, nil)
+	if err != nil || len(values) == 0 {
+		return nil, errors.Errorf("invalid response = %v", reply)
+	}
+	role, err := redigo.String(values[0], nil)
+	if err != nil {
+		return nil, errors.Errorf("invalid response[0] = %v", values[0])
+	}
+	var x = &RoleInfo{Role: strings.ToLower(role)}
+	switch x.Role {
+	case "master":
+		if len(values) != 3 {
+			return nil, errors.Errorf("invalid response = %v", reply)
+		}
+		if x.ReplOffset, err = redigo.Int64(values[1], nil); err != nil {
+			return nil, errors.Errorf("invalid response[1] = %v", values[1])
+		}
+		slaves, err := redigo.Values(values[2], nil)
+		if err != nil {
+			return nil, errors.Errorf("invalid response[2] = %v", values[2])
+		}
+		for i, slave := range slaves {
+			p, err := redigo.Strings(slave, nil)
+			if err != nil || len(p) != 3 {
+				return nil, errors.Errorf("invalid response[2][%d] = %v", i, slave)
+			}
+			offset, err := strconv.ParseInt(p[2], 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid response[2][%d] = %v", i, slave)
+			}
+			x.Slaves = append(x.Slaves, RoleSlave{
+				Host: p[0], Port: p[1], Offset: offset,
+			})
+		}
+	case "slave":
+		if len(values) != 5 {
+			return nil, errors.Errorf("invalid response = %v", reply)
+		}
+		if x.MasterHost, err = redigo.String(values[1], nil); err != nil {
+			return nil, errors.Errorf("invalid response[1] = %v", values[1])
+		}
+		port, err := redigo.Int(values[2], nil)
+		if err != nil {
+			return nil, errors.Errorf("invalid response[2] = %v", values[2])
+		}
+		x.MasterPort = strconv.Itoa(port)
+		if x.ReplOffset, err = redigo.Int64(values[4], nil); err != nil {
+			return nil, errors.Errorf("invalid response[4] = %v", values[4])
+		}
+	}
+	return x, nil
+}
+
+var ErrNotSlave = errors.New("redis server is not a slave")
+
+// SetMasterAndVerify calls SetMaster and then re-reads INFO replication until
+// it reports the new master, or timeout expires. SLAVEOF replies OK before
+// the server actually switches, so the reply alone proves nothing.
+func (c *Client) SetMasterAndVerify(master string, timeout time.Duration) error {
+	if err := c.SetMaster(master); err != nil {
+		return err
+	}
+	var expire = time.Now().Add(timeout)
+	for {
+		r, err := c.ReplicationInfo()
+		if err != nil {
+			return err
+		}
+		if master == "NO:ONE" {
+			if r.Role == "master" {
+				return nil
+			}
+		} else if r.Role == "slave" && r.MasterAddr() == master {
+			return nil
+		}
+		if !time.Now().Before(expire) {
+			return errors.Errorf("set master to %s failed, role = %s, master = %s", master, r.Role, r.MasterAddr())
+		}
+		time.Sleep(math2.MinDuration(time.Millisecond*100, expire.Sub(time.Now())))
+	}
+}
+
+// WaitForSync polls INFO replication every poll interval until the slave has
+// finished its initial sync with the master, or ctx is done.
+func (c *Client) WaitForSync(ctx context.Context, poll time.Duration) error {
+	for {
+		r, err := c.ReplicationInfo()
+		if err != nil {
+			return err
+		}
+		if r.Role != "slave" {
+			return errors.Trace(ErrNotSlave)
+		}
+		if r.MasterLinkStatus == "up" && !r.MasterSyncInProgress {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(poll):
+		}
+	}
+}
+
+// WaitForCatchUp is like WaitForSync, but also waits until the slave trails
+// master by at most maxLag bytes. The last observed state is reported if ctx
+// is done first.
+func (c *Client) WaitForCatchUp(ctx context.Context, master *Client, maxLag int64, poll time.Duration) error {
+	for {
+		r, err := c.ReplicationInfo()
+		if err != nil {
+			return err
+		}
+		if r.Role != "slave" {
+			return errors.Trace(ErrNotSlave)
+		}
+		m, err := master.ReplOffset()
+		if err != nil {
+			return err
+		}
+		lag := m - r.SlaveReplOffset
+		if r.MasterLinkStatus == "up" && !r.MasterSyncInProgress && lag <= maxLag {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("wait for catch up failed: %s, master_link_status = %s, lag = %d",
+				ctx.Err(), r.MasterLinkStatus, lag)
+		case <-time.After(poll):
+		}
+	}
+}
+
+func (c *Client) Slaves() ([]string, error) {
+	r, err := c.ReplicationInfo()
+	if err != nil {
+		return nil, err
+	}
+	var slaves = []string{}
+	for _, slave := range r.Slaves {
+		if slave.State == "online" {
+			slaves = append(slaves, slave.Addr)
+		}
+	}
+	return slaves, nil
+}
+
+func (c *Client) ReplOffset() (int64, error) {
+	r, err := c.ReplicationInfo()
+	if err != nil {
+		return 0, err
+	}
+	if r.Role == "slave" {
+		return r.SlaveReplOffset, nil
+	}
+	return r.MasterReplOffset, nil
+}
+
+// ReplLag returns how many bytes of the replication stream c trails behind
+// master. A negative or huge value means the link is broken, callers must
+// treat it as unsafe for promotion.
+//
+// The two offsets are read one after another, so it's an approximation. The
+// slave is read first, writes in between can only make the lag look larger.
+func (c *Client) ReplLag(master *Client) (int64, error) {
+	s, err := c.ReplOffset()
+	if err != nil {
+		return 0, err
+	}
+	m, err := master.ReplOffset()
+	if err != nil {
+		return 0, err
+	}
+	return m - s, nil
+}
+
+type ClientInfo struct {
+	Id    int64  `json:"id"`
+	Addr  string `json:"addr"`
+	Name  string `json:"name,omitempty"`
+	Age   int64  `json:"age"`
+	Idle  int64  `json:"idle"`
+	Flags string `json:"flags"`
+	DB    int    `json:"db"`
+	Cmd   string `json:"cmd"`
+}
+
+func (c *Client) ClientList() ([]*ClientInfo, error) {
+	text, err := redigo.String(c.Do("CLIENT", "LIST"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parseClientList(text), nil
+}
+
+func (c *Client) KillClient(addr string) (bool, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return false, errors.Trace(err)
+	}
+	return c.killClient("ADDR", addr)
+}
+
+func (c *Client) KillClientById(id int64) (bool, error) {
+	return c.killClient("ID", id)
+}
+
+func (c *Client) killClient(filter string, value interface{}) (bool, error) {
+	n, err := redigo.Int(c.Do("CLIENT", "KILL", filter, value))
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return n != 0, nil
+}
+
+func parseClientList(text string) []*ClientInfo {
+	var clients []*ClientInfo
+	for _, line := range strings.Split(text, "\n") {
+		var x = &ClientInfo{}
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "id":
+				x.Id, _ = strconv.ParseInt(kv[1], 10, 64)
+			case "addr":
+				x.Addr = kv[1]
+			case "name":
+				x.Name = kv[1]
+			case "age":
+				x.Age, _ = strconv.ParseInt(kv[1], 10, 64)
+			case "idle":
+				x.Idle, _ = strconv.ParseInt(kv[1], 10, 64)
+			case "flags":
+				x.Flags = kv[1]
+			case "db":
+				x.DB, _ = strconv.Atoi(kv[1])
+			case "cmd":
+				x.Cmd = kv[1]
+			}
+		}
+		if x.Addr != "" {
+			clients = append(clients, x)
+		}
+	}
+	return clients
+}
+
+func parseInfoInt(info map[string]string, key string) int64 {
+	n, _ := strconv.ParseInt(info[key], 10, 64)
+	return n
+}
+
+func parseKeyspace(info map[string]string) map[int]KeyspaceStat {
+	var keyspace = make(map[int]KeyspaceStat)
+	for key, value := range info {
+		if !strings.HasPrefix(key, "db") {
+			continue
+		}
+		n, err := strconv.Atoi(key[2:])
+		if err != nil {
+			continue
+		}
+		keyspace[n] = parseKeyspaceStat(value)
+	}
+	return keyspace
+}
+
+func parseKeyspaceStat(value string) KeyspaceStat {
+	var stat KeyspaceStat
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, _ := strconv.ParseInt(kv[1], 10, 64)
+		switch kv[0] {
+		case "keys":
+			stat.Keys = n
+		case "expires":
+			stat.Expires = n
+		case "avg_ttl":
+			stat.AvgTTL = n
+		}
+	}
+	return stat
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestNewServerInfo(t *testing.T) {
+	s := NewServerInfo(map[string]string{
+		"role":              "slave",
+		"uptime_in_seconds": "3600",
+		"used_memory":       "1048576",
+		"maxmemory":         "0",
+
+		"master_link_status": "up",
+
+		"db0": "keys=10,expires=2,avg_ttl=300",
+		"db3": "keys=1,expires=0,avg_ttl=0",
+	})
+	assert.Must(s.Role == "slave")
+	assert.Must(s.UptimeInSeconds == 3600)
+	assert.Must(s.UsedMemory == 1048576)
+	assert.Must(s.MaxMemory == 0)
+	assert.Must(s.ConnectedSlaves == 0)
+	assert.Must(s.MasterLinkStatus == "up")
+	assert.Must(len(s.Keyspace) == 2)
+	assert.Must(s.Keyspace[0] == KeyspaceStat{Keys: 10, Expires: 2, AvgTTL: 300})
+	assert.Must(s.Keyspace[3] == KeyspaceStat{Keys: 1})
+}
+
+func TestNewMemoryStats(t *testing.T) {
+	var info = map[string]string{
+		"used_memory":             "1048576",
+		"used_memory_rss":         "2097152",
+		"maxmemory":               "0",
+		"mem_fragmentation_ratio": "2.00",
+		"evicted_keys":            "7",
+	}
+	m, err := NewMemoryStats(info)
+	assert.MustNoError(err)
+	assert.Must(*m == MemoryStats{
+		UsedMemory: 1048576, UsedMemoryRss: 2097152,
+		MemFragmentationRatio: 2, EvictedKeys: 7,
+	})
+	assert.Must(m.UsageRatio() == 0)
+
+	m.MaxMemory = 4194304
+	assert.Must(m.UsageRatio() == 0.25)
+
+	delete(info, "evicted_keys")
+	_, err = NewMemoryStats(info)
+	assert.Must(err != nil)
+
+	info["evicted_keys"] = "7"
+	info["mem_fragmentation_ratio"] = "nan?"
+	_, err = NewMemoryStats(info)
+	assert.Must(err != nil)
+}
+
+func TestNewReplicationInfo(t *testing.T) {
+	r := NewReplicationInfo(parseInfo(strings.Join([]string{
+		"# Replication",
+		"role:master",
+		"connected_slaves:2",
+		"slave0:ip=10.0.0.2,port=6380,state=online,offset=1200,lag=0",
+		"slave1:ip=10.0.0.3,port=6380,state=wait_bgsave,offset=0,lag=1",
+		"master_repl_offset:1234",
+	}, "\r\n")))
+	assert.Must(r.Role == "master")
+	assert.Must(r.MasterAddr() == "")
+	assert.Must(r.MasterReplOffset == 1234)
+	assert.Must(len(r.Slaves) == 2)
+	assert.Must(r.Slaves[0] == SlaveInfo{Addr: "10.0.0.2:6380", State: "online", Offset: 1200})
+	assert.Must(r.Slaves[1].State == "wait_bgsave" && r.Slaves[1].Lag == 1)
+
+	r = NewReplicationInfo(map[string]string{
+		"role":               "slave",
+		"master_host":        "10.0.0.1",
+		"master_port":        "6379",
+		"master_link_status": "up",
+		"slave_repl_offset":  "1200",
+	})
+	assert.Must(r.MasterAddr() == "10.0.0.1:6379")
+	assert.Must(r.MasterLinkStatus == "up")
+	assert.Must(r.SlaveReplOffset == 1200)
+	assert.Must(len(r.Slaves) == 0)
+}
+
+func TestParseClientList(t *testing.T) {
+	clients := parseClientList(strings.Join([]string{
+		"id=3 addr=127.0.0.1:52555 fd=8 name=codis-proxy age=855 idle=0 flags=N db=0 sub=0 psub=0 multi=-1 cmd=client",
+		"garbage line",
+		"id=4 addr=10.0.0.2:6380 fd=9 name= age=12 idle=3 flags=S db=2 cmd=replconf",
+		"",
+	}, "\n"))
+	assert.Must(len(clients) == 2)
+	assert.Must(*clients[0] == ClientInfo{
+		Id: 3, Addr: "127.0.0.1:52555", Name: "codis-proxy", Age: 855, Flags: "N", Cmd: "client",
+	})
+	assert.Must(*clients[1] == ClientInfo{
+		Id: 4, Addr: "10.0.0.2:6380", Age: 12, Idle: 3, Flags: "S", DB: 2, Cmd: "replconf",
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// URL describes a backend given as redis://[[user]:auth@]host[:port][/db]
+// or rediss:// for tls, the port defaults to 6379. The query parameters
+// timeout and dial_timeout accept Go durations, e.g. ?timeout=5s.
+type URL struct {
+	Addr string
+	User string
+	Auth string
+
+	Database int
+
+	TLSEnabled bool
+
+	Timeout     time.Duration
+	DialTimeout time.Duration
+}
+
+func ParseURL(rawurl string) (*URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var r = &URL{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		r.TLSEnabled = true
+	default:
+		return nil, errors.Errorf("invalid url scheme = %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid url = %q, missing host", rawurl)
+	}
+	if u.Port() == "" {
+		r.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	} else {
+		r.Addr = net.JoinHostPort(u.Hostname(), u.Port())
+	}
+	if u.User != nil {
+		r.User = u.User.Username()
+		r.Auth, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid url database = %q", db)
+		}
+		r.Database = n
+	}
+	for key, ptr := range map[string]*time.Duration{
+		"timeout":      &r.Timeout,
+		"dial_timeout": &r.DialTimeout,
+	} {
+		if v := u.Query().Get(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return nil, errors.Errorf("invalid url %s = %q", key, v)
+			}
+			*ptr = d
+		}
+	}
+	return r, nil
+}
+
+func (r *URL) Option() *ClientOption {
+	return &ClientOption{
+		User: r.User, Auth: r.Auth,
+		Database:    r.Database,
+		Timeout:     r.Timeout,
+		DialTimeout: r.DialTimeout,
+		TLSEnabled:  r.TLSEnabled,
+	}
+}
+
+// NewClientFromURL dials the backend described by rawurl, see URL.
+func NewClientFromURL(rawurl string) (*Client, error) {
+	r, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithOption(r.Addr, r.Option())
+}